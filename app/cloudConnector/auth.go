@@ -0,0 +1,100 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	basicAuth        = "basic"
+	bearerAuth       = "bearer"
+	apiKeyHeaderAuth = "apikey-header"
+	apiKeyQueryAuth  = "apikey-query"
+
+	defaultAPIKeyHeader = "X-API-Key"
+	defaultAPIKeyParam  = "api_key"
+
+	redacted = "[REDACTED]"
+)
+
+// staticAuthHeader returns the header of the basic, bearer and apikey-header auth types
+func staticAuthHeader(authType string, auth Auth) (http.Header, error) {
+	header := http.Header{}
+	switch authType {
+	case basicAuth:
+		if auth.Username == "" {
+			return nil, errors.New("basic auth requires a username")
+		}
+		request := http.Request{Header: header}
+		request.SetBasicAuth(auth.Username, auth.Password)
+	case bearerAuth:
+		if auth.Token == "" {
+			return nil, errors.New("bearer auth requires a token")
+		}
+		header.Set("Authorization", "Bearer "+auth.Token)
+	case apiKeyHeaderAuth:
+		if auth.APIKey == "" {
+			return nil, errors.New("apikey-header auth requires an apikey")
+		}
+		headerName := auth.HeaderName
+		if headerName == "" {
+			headerName = defaultAPIKeyHeader
+		}
+		header.Set(headerName, auth.APIKey)
+	default:
+		return nil, errors.Errorf("unsupported auth type %s", authType)
+	}
+	return header, nil
+}
+
+// addAPIKeyQuery adds the API key to the query string of the request
+func addAPIKeyQuery(request *http.Request, auth Auth) error {
+	if auth.APIKey == "" {
+		return errors.New("apikey-query auth requires an apikey")
+	}
+	paramName := auth.ParamName
+	if paramName == "" {
+		paramName = defaultAPIKeyParam
+	}
+	query := request.URL.Query()
+	query.Set(paramName, auth.APIKey)
+	request.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// redactRequestError keeps secrets added to the request URL, such as query API keys, out of the error
+// since errors end up in the logs, job statuses, dead letters and callbacks
+func redactRequestError(err error, webhook Webhook) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		redactedErr := *urlErr
+		redactedErr.URL = webhook.URL
+		return &redactedErr
+	}
+	return err
+}
+
+// Redacted returns a copy of the auth settings with their secrets replaced, so they can safely be logged or returned by the API
+func (auth Auth) Redacted() Auth {
+	for _, secret := range auth.secrets() {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return auth
+}
+
+// String prints the auth settings with their secrets redacted, so they can safely be logged
+func (auth Auth) String() string {
+	// Print through a type without the String method to avoid recursing
+	type plainAuth Auth
+	return fmt.Sprintf("%+v", plainAuth(auth.Redacted()))
+}
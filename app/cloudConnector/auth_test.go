@@ -0,0 +1,112 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStaticAuthTypes(t *testing.T) {
+	testCases := []struct {
+		auth  Auth
+		check func(request *http.Request) bool
+	}{
+		{
+			auth: Auth{AuthType: "basic", Username: "store", Password: "s3cret"},
+			check: func(request *http.Request) bool {
+				username, password, ok := request.BasicAuth()
+				return ok && username == "store" && password == "s3cret"
+			},
+		},
+		{
+			auth: Auth{AuthType: "Bearer", Token: "static-token"},
+			check: func(request *http.Request) bool {
+				return request.Header.Get("Authorization") == "Bearer static-token"
+			},
+		},
+		{
+			auth: Auth{AuthType: "apikey-header", APIKey: "key-1"},
+			check: func(request *http.Request) bool {
+				return request.Header.Get(defaultAPIKeyHeader) == "key-1"
+			},
+		},
+		{
+			auth: Auth{AuthType: "apikey-header", APIKey: "key-2", HeaderName: "Ocp-Apim-Subscription-Key"},
+			check: func(request *http.Request) bool {
+				return request.Header.Get("Ocp-Apim-Subscription-Key") == "key-2"
+			},
+		},
+		{
+			auth: Auth{AuthType: "apikey-query", APIKey: "key 3"},
+			check: func(request *http.Request) bool {
+				return request.URL.Query().Get(defaultAPIKeyParam) == "key 3" && request.URL.Query().Get("store") == "1"
+			},
+		},
+		{
+			auth: Auth{AuthType: "apikey-query", APIKey: "key-4", ParamName: "code"},
+			check: func(request *http.Request) bool {
+				return request.URL.Query().Get("code") == "key-4"
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !testCase.check(request) {
+				writer.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+
+		webHook := GenerateWebhook(testMockServer.URL, false, http.MethodGet)
+		webHook.URL += "?store=1"
+		webHook.Auth = testCase.auth
+
+		if _, err := ProcessWebhook(webHook, ""); err != nil {
+			t.Errorf("%s call was not authenticated: %s", testCase.auth.AuthType, err.Error())
+		}
+		testMockServer.Close()
+	}
+}
+
+func TestAuthSecretsAreRedacted(t *testing.T) {
+	auth := Auth{
+		AuthType:     "basic",
+		Endpoint:     "http://idp/oauth",
+		Username:     "store",
+		Password:     "password-secret",
+		Token:        "token-secret",
+		APIKey:       "apikey-secret",
+		ClientSecret: "client-secret",
+		Data:         "data-secret",
+	}
+
+	printed := fmt.Sprintf("%v", Webhook{URL: "http://localhost", Auth: auth})
+	if strings.Contains(printed, "secret") {
+		t.Errorf("Expected secrets to be redacted, printed %s", printed)
+	}
+	if !strings.Contains(printed, "store") || !strings.Contains(printed, "http://idp/oauth") {
+		t.Errorf("Expected non secret fields to be printed, printed %s", printed)
+	}
+}
+
+func TestAPIKeyQueryRedactedFromErrors(t *testing.T) {
+	// Nothing listens on this port so the call fails with the request URL in the error
+	webHook := GenerateWebhook("http://127.0.0.1:1", false, http.MethodGet)
+	webHook.Auth = Auth{AuthType: "apikey-query", APIKey: "query-secret"}
+
+	_, err := ProcessWebhook(webHook, "")
+	if err == nil {
+		t.Fatal("Expected the call to fail")
+	}
+	if strings.Contains(err.Error(), "query-secret") {
+		t.Errorf("Expected the API key to be redacted, received %s", err.Error())
+	}
+}
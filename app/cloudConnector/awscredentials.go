@@ -0,0 +1,99 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRoleSessionName identifies the service in the CloudTrail events of an assumed role
+	defaultRoleSessionName = "cloud-connector"
+	// roleCredentialsCapacity bounds the number of assumed roles kept
+	roleCredentialsCapacity = 256
+	// Assumed role credentials are renewed this long before they expire
+	roleExpiryWindow = time.Minute
+)
+
+// ErrAwsDefaultCredentialsDisabled is returned for an upload without AWS keys when the default credential chain is disabled
+var ErrAwsDefaultCredentialsDisabled = errors.New("AWS access keys are required, the default credential chain is disabled")
+
+// awsDefaultCredentials allows the uploads without keys to use the credentials of the service, see SetAwsDefaultCredentials
+var awsDefaultCredentials = false
+
+// SetAwsDefaultCredentials sets whether uploads without AWS keys use the SDK default credential chain:
+// environment variables, shared configuration files and the ECS or EC2 instance role of the service.
+// It is disabled by default: once enabled any caller of the API can upload with the permissions of the service,
+// and have it assume any role those permissions allow.
+func SetAwsDefaultCredentials(enabled bool) {
+	awsDefaultCredentials = enabled
+}
+
+// AwsCredentials returns the static credentials of the connection data, with their session token for temporary credentials.
+// Without access keys it returns nil so the session falls back to the SDK default credential chain.
+func AwsCredentials(data AwsConnectionData) (*credentials.Credentials, error) {
+	if data.AccessKeyID != "" {
+		return credentials.NewStaticCredentials(data.AccessKeyID, data.SecretAccessKey, data.SessionToken), nil
+	}
+	if !awsDefaultCredentials {
+		return nil, ErrAwsDefaultCredentialsDisabled
+	}
+	return nil, nil
+}
+
+var roleCredentials = struct {
+	sync.Mutex
+	entries map[string]*credentials.Credentials
+}{entries: map[string]*credentials.Credentials{}}
+
+// AssumeAwsRole returns the credentials of the RoleARN role, assumed through STS with the credentials of the session.
+// The credentials are kept and renewed before they expire, so uploads do not call STS every time.
+func AssumeAwsRole(sess *session.Session, data AwsConnectionData) *credentials.Credentials {
+	sessionName := data.RoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	// The role is only shared by uploads assuming it with the same credentials and STS settings
+	caller := "default"
+	if data.AccessKeyID != "" {
+		caller = data.AccessKeyID + "\n" + data.SecretAccessKey + "\n" + data.SessionToken
+	}
+	key := hashSecret(caller + "\n" + data.RoleARN + "\n" + data.ExternalID + "\n" + sessionName + "\n" +
+		aws.StringValue(sess.Config.Region) + "\n" + aws.StringValue(sess.Config.Endpoint))
+
+	roleCredentials.Lock()
+	defer roleCredentials.Unlock()
+
+	if role, ok := roleCredentials.entries[key]; ok {
+		return role
+	}
+	if len(roleCredentials.entries) >= roleCredentialsCapacity {
+		// Dropped roles are assumed again on their next use
+		for dropped := range roleCredentials.entries {
+			delete(roleCredentials.entries, dropped)
+			break
+		}
+	}
+
+	role := stscreds.NewCredentials(sess, data.RoleARN, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = sessionName
+		provider.ExpiryWindow = roleExpiryWindow
+		if data.ExternalID != "" {
+			provider.ExternalID = aws.String(data.ExternalID)
+		}
+	})
+	roleCredentials.entries[key] = role
+	return role
+}
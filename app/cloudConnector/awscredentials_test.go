@@ -0,0 +1,107 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestAwsCredentials(t *testing.T) {
+	defer SetAwsDefaultCredentials(false)
+
+	static, err := AwsCredentials(AwsConnectionData{AccessKeyID: "ASIAKEY", SecretAccessKey: "s3cret", SessionToken: "session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := static.Get()
+	if err != nil || value.AccessKeyID != "ASIAKEY" || value.SecretAccessKey != "s3cret" || value.SessionToken != "session" {
+		t.Errorf("Expected the temporary credentials of the request, received %+v %v", value, err)
+	}
+
+	if _, err := AwsCredentials(AwsConnectionData{Bucket: "bucket"}); err != ErrAwsDefaultCredentialsDisabled {
+		t.Errorf("Expected the default credential chain to be disabled by default, received %v", err)
+	}
+	SetAwsDefaultCredentials(true)
+	if chain, err := AwsCredentials(AwsConnectionData{Bucket: "bucket"}); chain != nil || err != nil {
+		t.Errorf("Expected the default credential chain without keys, received %v %v", chain, err)
+	}
+	SetAwsDefaultCredentials(false)
+	if _, err := AwsCredentials(AwsConnectionData{Bucket: "bucket"}); err != ErrAwsDefaultCredentialsDisabled {
+		t.Errorf("Expected keys to be required without the default credential chain, received %v", err)
+	}
+}
+
+func TestAssumeAwsRole(t *testing.T) {
+	var calls int32
+	fakeSTS := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if err := request.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if request.Form.Get("Action") != "AssumeRole" || request.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/uploader" ||
+			request.Form.Get("ExternalId") != "store-105" || request.Form.Get("RoleSessionName") != defaultRoleSessionName {
+			t.Errorf("Unexpected AssumeRole request %v", request.Form)
+		}
+		if !strings.Contains(request.Header.Get("Authorization"), "Credential=ASIAKEY/") {
+			t.Errorf("Expected the role to be assumed with the credentials of the request, received %s", request.Header.Get("Authorization"))
+		}
+		_, _ = writer.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+			<AssumeRoleResult>
+				<Credentials>
+					<AccessKeyId>ASIAROLE</AccessKeyId>
+					<SecretAccessKey>role-secret</SecretAccessKey>
+					<SessionToken>role-session</SessionToken>
+					<Expiration>2099-01-01T00:00:00Z</Expiration>
+				</Credentials>
+			</AssumeRoleResult>
+		</AssumeRoleResponse>`))
+	}))
+	defer fakeSTS.Close()
+
+	data := AwsConnectionData{
+		AccessKeyID:     "ASIAKEY",
+		SecretAccessKey: "s3cret",
+		SessionToken:    "session",
+		RoleARN:         "arn:aws:iam::123456789012:role/uploader",
+		ExternalID:      "store-105",
+	}
+	newSession := func() *session.Session {
+		static, _ := AwsCredentials(data)
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String("us-west-2"),
+			Endpoint:    aws.String(fakeSTS.URL),
+			Credentials: static,
+			MaxRetries:  aws.Int(0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+
+	for i := 0; i < 2; i++ {
+		value, err := AssumeAwsRole(newSession(), data).Get()
+		if err != nil || value.AccessKeyID != "ASIAROLE" || value.SecretAccessKey != "role-secret" || value.SessionToken != "role-session" {
+			t.Errorf("Expected the credentials of the role, received %+v %v", value, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the role credentials to be reused until they expire, STS was called %d times", calls)
+	}
+
+	data.ExternalID = "store-106"
+	if AssumeAwsRole(newSession(), data) == AssumeAwsRole(newSession(), AwsConnectionData{RoleARN: data.RoleARN}) {
+		t.Error("Expected callers with different credentials not to share a role")
+	}
+}
@@ -0,0 +1,111 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	callbackConnectionTimeout = 10
+	callbackBodyMaxSize       = 4 << 10
+)
+
+// notifyCallback posts the outcome of an async delivery to the callback of the webhook, if it has one.
+// Callbacks target local services so they are never sent through a proxy, even one set in the environment, and are not retried.
+func notifyCallback(delivery *Delivery, status string, response *WebhookResponse, deliveryErr error) {
+	callback := delivery.Webhook.Callback
+	if callback == nil || callback.URL == "" {
+		return
+	}
+
+	mSuccess := metrics.GetOrRegisterGauge("CloudConnector.notifyCallback.Success", nil)
+	mError := metrics.GetOrRegisterGauge("CloudConnector.notifyCallback.Error", nil)
+
+	if err := postCallback(*callback, newCallbackOutcome(delivery, status, response, deliveryErr, callback.MaxBodySize)); err != nil {
+		log.WithFields(log.Fields{
+			"Method":       "notifyCallback",
+			"Action":       "post the async delivery outcome",
+			"TraceID":      delivery.ID,
+			"Callback URL": callback.URL,
+		}).Error(err.Error())
+		mError.Update(1)
+		return
+	}
+	mSuccess.Update(1)
+}
+
+func newCallbackOutcome(delivery *Delivery, status string, response *WebhookResponse, deliveryErr error, maxBodySize int) CallbackOutcome {
+	if maxBodySize <= 0 {
+		maxBodySize = callbackBodyMaxSize
+	}
+
+	outcome := CallbackOutcome{
+		TraceID:  delivery.ID,
+		Status:   status,
+		Attempts: delivery.Attempts,
+	}
+	if response != nil {
+		outcome.StatusCode = response.StatusCode
+		outcome.Header = response.Header
+		outcome.Body = response.Body
+		if len(outcome.Body) > maxBodySize {
+			outcome.Body = outcome.Body[:maxBodySize]
+			outcome.Truncated = true
+		}
+	}
+	if deliveryErr != nil {
+		outcome.Error = deliveryErr.Error()
+	}
+	return outcome
+}
+
+func postCallback(callback Callback, outcome CallbackOutcome) error {
+	client, err := getDirectHTTPClient(callbackConnectionTimeout, Auth{})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return errors.Wrapf(err, "unable to marshal callback outcome")
+	}
+
+	request, err := http.NewRequest(http.MethodPost, callback.URL, bytes.NewBuffer(data))
+	if err != nil {
+		return errors.Wrapf(err, "unable to create callback request")
+	}
+	for key, values := range callback.Header {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	request.Header.Set("content-type", jsonApplication)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "unable to post callback: %s", callback.URL)
+	}
+	defer func() {
+		// Drain the body so the connection can be reused
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("callback %s returned StatusCode %d", callback.URL, response.StatusCode)
+	}
+	return nil
+}
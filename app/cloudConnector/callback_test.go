@@ -0,0 +1,110 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCallbackServer(t *testing.T) (*httptest.Server, chan CallbackOutcome) {
+	outcomes := make(chan CallbackOutcome, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Callback-Token") != "secret" {
+			t.Errorf("Expected callback header to be forwarded, received %v", request.Header)
+		}
+		var outcome CallbackOutcome
+		if err := json.NewDecoder(request.Body).Decode(&outcome); err != nil {
+			t.Errorf("Unable to decode callback outcome: %s", err.Error())
+		}
+		outcomes <- outcome
+	}))
+	return server, outcomes
+}
+
+func waitForCallback(t *testing.T, outcomes chan CallbackOutcome) CallbackOutcome {
+	select {
+	case outcome := <-outcomes:
+		return outcome
+	case <-time.After(5 * time.Second):
+		t.Fatal("Callback was not called")
+	}
+	return CallbackOutcome{}
+}
+
+func TestCallbackReceivesSuccessfulOutcome(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Cloud", "accepted")
+		_, _ = writer.Write([]byte(strings.Repeat("a", 20)))
+	}))
+	defer testMockServer.Close()
+	callbackServer, outcomes := newTestCallbackServer(t)
+	defer callbackServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+	queue.Start()
+	defer queue.Stop()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Callback = &Callback{
+		URL:         callbackServer.URL,
+		Header:      http.Header{"X-Callback-Token": {"secret"}},
+		MaxBodySize: 8,
+	}
+	if err := queue.Enqueue("trace-callback", webHook); err != nil {
+		t.Fatal(err)
+	}
+
+	outcome := waitForCallback(t, outcomes)
+	if outcome.TraceID != "trace-callback" || outcome.Status != JobSucceeded || outcome.Attempts != 1 {
+		t.Errorf("Unexpected callback outcome %v", outcome)
+	}
+	if outcome.StatusCode != http.StatusOK || outcome.Header.Get("X-Cloud") != "accepted" {
+		t.Errorf("Expected the cloud response status and header, received %d %v", outcome.StatusCode, outcome.Header)
+	}
+	if string(outcome.Body) != "aaaaaaaa" || !outcome.Truncated {
+		t.Errorf("Expected the body to be truncated to 8 bytes, received %q truncated %v", outcome.Body, outcome.Truncated)
+	}
+}
+
+func TestCallbackReceivesFailedOutcome(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("missing sku"))
+	}))
+	defer testMockServer.Close()
+	callbackServer, outcomes := newTestCallbackServer(t)
+	defer callbackServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+	queue.Start()
+	defer queue.Stop()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Callback = &Callback{
+		URL:    callbackServer.URL,
+		Header: http.Header{"X-Callback-Token": {"secret"}},
+	}
+	if err := queue.Enqueue("trace-callback-failed", webHook); err != nil {
+		t.Fatal(err)
+	}
+
+	outcome := waitForCallback(t, outcomes)
+	if outcome.Status != JobFailed || outcome.StatusCode != http.StatusBadRequest || outcome.Error == "" {
+		t.Errorf("Expected a failed outcome with status 400 and an error, received %v", outcome)
+	}
+	if string(outcome.Body) != "missing sku" || outcome.Truncated {
+		t.Errorf("Expected the whole body, received %q truncated %v", outcome.Body, outcome.Truncated)
+	}
+}
@@ -8,12 +8,9 @@ package cloudConnector
 
 import (
 	"bytes"
-	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
-	"sync"
 	"time"
 
 	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
@@ -30,17 +27,76 @@ const (
 	responseMaxSize          = 16 << 20
 )
 
-var accessTokens sync.Map
+var accessTokens = newTokenCache(tokenCacheCapacity)
 
-// ProcessWebhook processes webhook requests
+// ProcessWebhook processes webhook requests, retrying failed calls as allowed by the webhook retry policy
 func ProcessWebhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
+	response, _, err := DeliverWebhook(webhook, proxy)
+	return response, err
+}
+
+// DeliverWebhook works like ProcessWebhook and also returns the outcome of every attempt made
+func DeliverWebhook(webhook Webhook, proxy string) (*WebhookResponse, []DeliveryAttempt, error) {
+	mRetry := metrics.GetOrRegisterGauge("CloudConnector.ProcessWebhook.Retry", nil)
+
+	// Credentials are resolved on every delivery so queued webhooks never hold the referenced secrets
+	webhook, err := resolveWebhookCredentials(webhook)
+	if err != nil {
+		return nil, []DeliveryAttempt{newDeliveryAttempt(nil, err)}, err
+	}
+
+	policy := webhook.Retry
+	if policy == nil {
+		policy = &RetryPolicy{MaxAttempts: 1}
+	}
 
-	log.Debugf("Webhook authType is: %s\n", webhook.Auth.AuthType)
+	var attempts []DeliveryAttempt
+	for attempt := 1; ; attempt++ {
+		response, err := processWebhookAttempt(webhook, proxy)
+		attempts = append(attempts, newDeliveryAttempt(response, err))
+		if err == nil || attempt >= policy.MaxAttempts || !policy.isRetryable(response, err) {
+			return response, attempts, err
+		}
+
+		delay, ok := policy.backoff(attempt, response)
+		if !ok {
+			return response, attempts, err
+		}
+
+		log.WithFields(log.Fields{
+			"Method":  "ProcessWebhook",
+			"Action":  "retry the webhook request",
+			"URL":     webhook.URL,
+			"Attempt": attempt,
+			"Delay":   delay,
+		}).Warn(err.Error())
+		mRetry.Update(1)
+		time.Sleep(delay)
+	}
+}
+
+func newDeliveryAttempt(response *WebhookResponse, err error) DeliveryAttempt {
+	attempt := DeliveryAttempt{
+		Timestamp: helper.UnixMilliNow(),
+	}
+	if response != nil {
+		attempt.StatusCode = response.StatusCode
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	return attempt
+}
+
+// processWebhookAttempt makes a single call to the webhook
+func processWebhookAttempt(webhook Webhook, proxy string) (*WebhookResponse, error) {
+
+	log.Debugf("Webhook auth is: %v\n", webhook.Auth)
 
 	// Check authentication type and run the appropriate POST or GET request.
 	switch strings.ToLower(webhook.Auth.AuthType) {
-	case oauth2:
-		// Call endpoint using authentication stored in the accessTokens map or using a newly retrieved token
+	case oauth2, jwtBearerAuth, serviceAccountAuth:
+		// Call endpoint using authentication stored in the accessTokens cache or using a newly retrieved token
 		response, err := getOrPostOAuth2Webhook(webhook, proxy)
 		// If the call fails and the status code returned is auth related then we need to try again
 		// It's possible the cached token timed out so we should attempt to get a new token before failing
@@ -54,11 +110,24 @@ func ProcessWebhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
 	}
 }
 
-// getAccessToken posts to get access token.
-func getAccessToken(webhook Webhook, proxy string) error {
-	// Metrics
+// getAccessToken returns the cached access token of the auth settings or requests a new one
+func getAccessToken(webhook Webhook, proxy string) (*accessToken, error) {
 	metrics.GetOrRegisterGauge(`CloudConnector.getAccessToken.Attempt`, nil).Update(1)
 	mSuccess := metrics.GetOrRegisterGauge(`CloudConnector.getAccessToken.Success`, nil)
+
+	token, err := accessTokens.get(newTokenCacheKey(webhook.Auth), func() (*accessToken, error) {
+		return requestAccessToken(webhook, proxy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	mSuccess.Update(1)
+	return token, nil
+}
+
+// requestAccessToken posts to the auth endpoint to get a new access token
+func requestAccessToken(webhook Webhook, proxy string) (*accessToken, error) {
+	// Metrics
 	mAuthenticateError := metrics.GetOrRegisterGauge("CloudConnector.getAccessToken.Auth-Error", nil)
 	mResponseStatusError := metrics.GetOrRegisterGauge("CloudConnector.getAccessToken.Status-Error", nil)
 	mDecoderError := metrics.GetOrRegisterGauge("CloudConnector.getAccessToken.Decoder-Error", nil)
@@ -66,72 +135,62 @@ func getAccessToken(webhook Webhook, proxy string) error {
 
 	log.Debugf("POST to endpoint %s\n with auth to get access token", webhook.Auth.Endpoint)
 
-	var accessTokenMap map[string]interface{}
-	endPointCache, ok := accessTokens.Load(webhook.Auth.Endpoint)
-	if ok && endPointCache != nil {
-		accessTokenMap = endPointCache.(map[string]interface{})
+	client, httpClientErr := getHTTPClient(oAuthConnectionTimeout, proxy, webhook.Auth)
+	if httpClientErr != nil {
+		return nil, errors.Wrapf(httpClientErr, "unable to %s webhook due to error in creating the HTTP client, proxy URL: %s", webhook.Method, proxy)
 	}
 
-	// Check for an existing token and if you find a valid token that isn't expired use that and don't call the endpoint
-	if accessTokenMap == nil || accessTokenMap["token_type"] == nil || accessTokenMap["token_type"] == "" ||
-		accessTokenMap["access_token"] == nil || accessTokenMap["access_token"] == "" ||
-		accessTokenMap["expires_in"] == nil || accessTokenMap["expires_in"] == "" ||
-		accessTokenMap["expirationDate"] == nil ||
-		(accessTokenMap["expirationDate"] != nil && accessTokenMap["expirationDate"].(int64) < helper.UnixMilliNow()) {
-		log.Debug("Getting access token")
-
-		client, httpClientErr := getHTTPClient(oAuthConnectionTimeout, proxy)
-		if httpClientErr != nil {
-			return errors.Wrapf(httpClientErr, "unable to %s webhook due to error in parsing proxy URL: %s", webhook.Method, proxy)
-		}
+	// The refresh token may have been rotated since it was configured
+	auth := webhook.Auth
+	if auth.RefreshToken != "" {
+		defer lockRefreshToken(webhook.Auth)()
+		auth.RefreshToken = refreshTokens.current(webhook.Auth)
+	}
 
-		// Make the POST to authenticate
-		request, _ := http.NewRequest("POST", webhook.Auth.Endpoint, nil)
-		request.Header.Set("Authorization", webhook.Auth.Data)
+	request, err := newTokenRequest(auth)
+	if err != nil {
+		return nil, err
+	}
 
-		authenticateTimer := time.Now()
-		response, err := client.Do(request)
-		if err != nil {
-			mAuthenticateError.Update(1)
-			return errors.Wrapf(err, "unable post auth webhook: %s", webhook.URL)
+	authenticateTimer := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		mAuthenticateError.Update(1)
+		return nil, errors.Wrapf(err, "unable post auth webhook: %s", webhook.URL)
+	}
+	mAuthenticateLatency.Update(time.Since(authenticateTimer))
+	defer func() {
+		if closeErr := response.Body.Close(); closeErr != nil {
+			log.WithFields(log.Fields{
+				"Method": "requestAccessToken",
+				"Action": "process the oath webhook request",
+			}).Error(closeErr.Error())
 		}
-		mAuthenticateLatency.Update(time.Since(authenticateTimer))
-		defer func() {
-			if closeErr := response.Body.Close(); closeErr != nil {
-				log.WithFields(log.Fields{
-					"Method": "postOAuth2Webhook",
-					"Action": "process the oath webhook request",
-				}).Fatal(err.Error())
-			}
-		}()
+	}()
 
+	tokenResponse, err := parseTokenResponse(response)
+	if err != nil {
 		if response.StatusCode != http.StatusOK {
 			mResponseStatusError.Update(int64(response.StatusCode))
-			webhookResponse, err := getWebhookResponse(response)
-			if err != nil {
-				log.Errorf("Posting to Auth endpoint returned error status of: %d", response.StatusCode)
-				return err
-			}
-			return errors.Wrapf(errors.New("webhook authentication error"+webhook.Auth.Endpoint), "StatusCode %d with following response %s",
-				webhookResponse.StatusCode, string(webhookResponse.Body))
-		}
-
-		if decErr := json.NewDecoder(response.Body).Decode(&accessTokenMap); decErr != nil {
+		} else {
 			mDecoderError.Update(1)
-			return decErr
 		}
+		return nil, errors.Wrapf(err, "webhook authentication error %s", webhook.Auth.Endpoint)
+	}
 
-		if accessTokenMap["expires_in"] != nil {
-			accessTokenMap["expirationDate"] = helper.UnixMilliNow() + int64(accessTokenMap["expires_in"].(float64)*1000)
+	if auth.RefreshToken != "" && tokenResponse.RefreshToken != "" && tokenResponse.RefreshToken != auth.RefreshToken {
+		if err := refreshTokens.rotate(webhook.Auth, tokenResponse.RefreshToken); err != nil {
+			// The new access token is still good, but the next exchange will likely be rejected
+			log.WithFields(log.Fields{
+				"Method":   "requestAccessToken",
+				"Action":   "persist the rotated refresh token",
+				"Endpoint": webhook.Auth.Endpoint,
+			}).Error(err.Error())
 		}
-		accessTokens.Store(webhook.Auth.Endpoint, accessTokenMap)
-
-		//Return access token
-		mSuccess.Update(1)
 	}
-	//Return access token
-	mSuccess.Update(1)
-	return nil
+
+	// Tokens without a lifetime are already expired for the cache, so they are only used once
+	return tokenResponse.accessToken(), nil
 }
 
 func getOrPostOAuth2Webhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
@@ -139,32 +198,23 @@ func getOrPostOAuth2Webhook(webhook Webhook, proxy string) (*WebhookResponse, er
 	var mAuthenticateLatency metrics.Timer
 
 	//Registering metrics based on HTTP method type.
-	if webhook.Method == http.MethodPost {
-		metrics.GetOrRegisterGauge(`CloudConnector.postOAuth2Webhook.Attempt`, nil).Update(1)
-		mSuccess = metrics.GetOrRegisterGauge(`CloudConnector.postOAuth2Webhook.Success`, nil)
-		mAuthenticateError = metrics.GetOrRegisterGauge("CloudConnector.postOAuth2Webhook.Auth-Error", nil)
-		mAuthenticateLatency = metrics.GetOrRegisterTimer(`CloudConnector.postOAuth2Webhook.Authenticate-Latency`, nil)
-		mResponseStatusError = metrics.GetOrRegisterGauge("CloudConnector.postOAuth2Webhook.Status-Error", nil)
-	} else {
-		metrics.GetOrRegisterGauge(`CloudConnector.getOAuth2Webhook.Attempt`, nil).Update(1)
-		mSuccess = metrics.GetOrRegisterGauge(`CloudConnector.getOAuth2Webhook.Success`, nil)
-		mAuthenticateError = metrics.GetOrRegisterGauge("CloudConnector.getOAuth2Webhook.Auth-Error", nil)
-		mAuthenticateLatency = metrics.GetOrRegisterTimer(`CloudConnector.getOAuth2Webhook.Authenticate-Latency`, nil)
-		mResponseStatusError = metrics.GetOrRegisterGauge("CloudConnector.getOAuth2Webhook.Status-Error", nil)
-
-	}
+	metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "OAuth2Webhook", "Attempt"), nil).Update(1)
+	mSuccess = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "OAuth2Webhook", "Success"), nil)
+	mAuthenticateError = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "OAuth2Webhook", "Auth-Error"), nil)
+	mAuthenticateLatency = metrics.GetOrRegisterTimer(webhookMetricName(webhook.Method, "OAuth2Webhook", "Authenticate-Latency"), nil)
+	mResponseStatusError = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "OAuth2Webhook", "Status-Error"), nil)
 
 	log.Debugf("%s to endpoint %s with auth", webhook.Method, webhook.URL)
 
-	//Set timeout and proxy for http client if present/needed
-	client, httpClientErr := getHTTPClient(webhookConnectionTimeout, proxy)
+	//Set timeout, proxy and client certificate for http client if present/needed
+	client, httpClientErr := getHTTPClient(webhookConnectionTimeout, proxy, webhook.Auth)
 	if httpClientErr != nil {
-		return nil, errors.Wrapf(httpClientErr, "unable to %s webhook due to error in parsing proxy URL: %s", webhook.Method, proxy)
+		return nil, errors.Wrapf(httpClientErr, "unable to %s webhook due to error in creating the HTTP client, proxy URL: %s", webhook.Method, proxy)
 	}
 
 	//Get Access token for the endpoint
 	authenticateTimer := time.Now()
-	accessTokenErr := getAccessToken(webhook, proxy)
+	token, accessTokenErr := getAccessToken(webhook, proxy)
 	if accessTokenErr != nil {
 		mAuthenticateError.Update(1)
 		return nil, accessTokenErr
@@ -172,32 +222,12 @@ func getOrPostOAuth2Webhook(webhook Webhook, proxy string) (*WebhookResponse, er
 	mAuthenticateLatency.Update(time.Since(authenticateTimer))
 
 	//Based on HTTP method type, set body and content type.
-	var request *http.Request
-	if webhook.Method == http.MethodPost {
-		mData, err := json.Marshal(webhook.Payload)
-		if err != nil {
-			return nil, errors.Wrapf(err, "unable to marshal payload")
-		}
-		request, _ = http.NewRequest(webhook.Method, webhook.URL, bytes.NewBuffer(mData))
-		request.Header.Set("content-type", jsonApplication)
-	} else {
-		request, _ = http.NewRequest(webhook.Method, webhook.URL, nil)
-
-	}
-
-	endPointCache, ok := accessTokens.Load(webhook.Auth.Endpoint)
-	endPointCacheMap := endPointCache.(map[string]interface{})
-	if ok && endPointCacheMap != nil {
-		if endPointCacheMap["token_type"].(string) != "" &&
-			endPointCacheMap["access_token"].(string) != "" {
-			request.Header.Set("Authorization", endPointCacheMap["token_type"].(string)+" "+
-				endPointCacheMap["access_token"].(string))
-		}
+	request, _, err := newWebhookRequest(webhook)
+	if err != nil {
+		return nil, err
 	}
 
-	if webhook.Header != nil {
-		request.Header = webhook.Header
-	}
+	mergeHeaders(request, webhook, http.Header{"Authorization": {token.authorization()}})
 
 	response, err := client.Do(request)
 	if err != nil {
@@ -212,9 +242,9 @@ func getOrPostOAuth2Webhook(webhook Webhook, proxy string) (*WebhookResponse, er
 		}
 	}()
 
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+	if !isSuccessStatus(response.StatusCode) {
 		if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
-			accessTokens.Delete(webhook.Auth.Endpoint)
+			accessTokens.invalidate(newTokenCacheKey(webhook.Auth))
 		}
 
 		mResponseStatusError.Update(int64(response.StatusCode))
@@ -223,7 +253,8 @@ func getOrPostOAuth2Webhook(webhook Webhook, proxy string) (*WebhookResponse, er
 		if responseErr != nil {
 			return nil, responseErr
 		}
-		return nil, errors.Wrapf(errors.New("request error:"), "StatusCode %d with following response %s",
+		// The response is returned along with the error so callers can decide whether the call is worth retrying
+		return webhookResponse, errors.Wrapf(errors.New("request error:"), "StatusCode %d with following response %s",
 			webhookResponse.StatusCode, string(webhookResponse.Body))
 	}
 
@@ -242,51 +273,46 @@ func getOrPostWebhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
 	var mWebhookLatency metrics.Timer
 
 	//Registering metrics based on HTTP method type.
+	statusErrorName := "Status-Error"
 	if webhook.Method == http.MethodPost {
-		metrics.GetOrRegisterGauge(`CloudConnector.postWebhook.Attempt`, nil).Update(1)
-		mSuccess = metrics.GetOrRegisterGauge(`CloudConnector.postWebhook.Success`, nil)
-		mMarshalError = metrics.GetOrRegisterGauge("CloudConnector.postWebhook.Marshal-Error", nil)
-		mWebhookResponseStatusError = metrics.GetOrRegisterGauge("CloudConnector.postWebhook.Webhook-Status-Error", nil)
-		mWebhookLatency = metrics.GetOrRegisterTimer(`CloudConnector.postWebhook.mWebhookPost-Latency`, nil)
-	} else {
-		metrics.GetOrRegisterGauge(`CloudConnector.getWebhook.Attempt`, nil).Update(1)
-		mSuccess = metrics.GetOrRegisterGauge(`CloudConnector.getWebhook.Success`, nil)
-		mMarshalError = metrics.GetOrRegisterGauge("CloudConnector.getWebhook.Marshal-Error", nil)
-		mWebhookResponseStatusError = metrics.GetOrRegisterGauge("CloudConnector.getWebhook.Status-Error", nil)
-		mWebhookLatency = metrics.GetOrRegisterTimer(`CloudConnector.getWebhook.mWebhookPost-Latency`, nil)
-
+		// The POST name predates the other methods and is kept for existing dashboards
+		statusErrorName = "Webhook-Status-Error"
 	}
+	metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "Webhook", "Attempt"), nil).Update(1)
+	mSuccess = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "Webhook", "Success"), nil)
+	mMarshalError = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "Webhook", "Marshal-Error"), nil)
+	mWebhookResponseStatusError = metrics.GetOrRegisterGauge(webhookMetricName(webhook.Method, "Webhook", statusErrorName), nil)
+	mWebhookLatency = metrics.GetOrRegisterTimer(webhookMetricName(webhook.Method, "Webhook", "mWebhookPost-Latency"), nil)
 
-	log.Debugf("%s to endpoint %s without auth", webhook.Method, webhook.URL)
+	log.Debugf("%s to endpoint %s with auth type %q", webhook.Method, webhook.URL, webhook.Auth.AuthType)
 
-	//Set timeout and proxy for http client if present/needed
-	client, httpClientErr := getHTTPClient(webhookConnectionTimeout, proxy)
+	//Set timeout, proxy and client certificate for http client if present/needed
+	client, httpClientErr := getHTTPClient(webhookConnectionTimeout, proxy, webhook.Auth)
 	if httpClientErr != nil {
-		return nil, errors.Wrapf(httpClientErr, "unable to %s webhook due to error in parsing proxy URL: %s", webhook.Method, proxy)
+		return nil, errors.Wrapf(httpClientErr, "unable to %s webhook due to error in creating the HTTP client, proxy URL: %s", webhook.Method, proxy)
 	}
 
 	//Request creation based on HTTTP mehtod type and adding headers
-	var request *http.Request
-	if webhook.Method == http.MethodPost {
-		mData, err := json.Marshal(webhook.Payload)
-		if err != nil {
-			mMarshalError.Update(1)
-			return nil, errors.Wrapf(err, "unable to marshal payload")
-		}
-		request, _ = http.NewRequest(webhook.Method, webhook.URL, bytes.NewBuffer(mData))
-		request.Header.Set("content-type", jsonApplication)
-	} else {
-		request, _ = http.NewRequest(webhook.Method, webhook.URL, nil)
+	request, body, err := newWebhookRequest(webhook)
+	if err != nil {
+		mMarshalError.Update(1)
+		return nil, err
 	}
 
-	if webhook.Header != nil {
-		request.Header = webhook.Header
+	authHeader, err := webhookAuthHeader(webhook, body)
+	if err != nil {
+		return nil, err
+	}
+	mergeHeaders(request, webhook, authHeader)
+
+	if err := authorizeWebhookRequest(request, webhook, body); err != nil {
+		return nil, err
 	}
 
 	getTimer := time.Now()
 	response, err := client.Do(request)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to %s endpoint: %s", webhook.Method, webhook.URL)
+		return nil, errors.Wrapf(redactRequestError(err, webhook), "unable to %s endpoint: %s", webhook.Method, webhook.URL)
 	}
 	defer func() {
 		if closeErr := response.Body.Close(); closeErr != nil {
@@ -297,13 +323,14 @@ func getOrPostWebhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
 		}
 	}()
 
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+	if !isSuccessStatus(response.StatusCode) {
 		mWebhookResponseStatusError.Update(int64(response.StatusCode))
 		webhookResponse, responseErr := getWebhookResponse(response)
 		if responseErr != nil {
 			return nil, responseErr
 		}
-		return nil, errors.Wrapf(errors.New("request error:"), "StatusCode %d with following response %s",
+		// The response is returned along with the error so callers can decide whether the call is worth retrying
+		return webhookResponse, errors.Wrapf(errors.New("request error:"), "StatusCode %d with following response %s",
 			webhookResponse.StatusCode, string(webhookResponse.Body))
 	}
 	mWebhookLatency.Update(time.Since(getTimer))
@@ -318,6 +345,80 @@ func getOrPostWebhook(webhook Webhook, proxy string) (*WebhookResponse, error) {
 	return webhookResponse, nil
 }
 
+// IsSafeMethod reports whether the HTTP method only reads from the webhook.
+// Safe calls are always made synchronously since their response is what the caller is after.
+func IsSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// hasRequestBody reports whether the payload is sent for the HTTP method.
+// POST, PUT and PATCH always carry the payload, DELETE only when one is given.
+func hasRequestBody(webhook Webhook) bool {
+	switch webhook.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	case http.MethodDelete:
+		return webhook.Payload != nil
+	default:
+		return false
+	}
+}
+
+// newWebhookRequest creates the request of the webhook and returns it along with the encoded body, which signatures cover
+func newWebhookRequest(webhook Webhook) (*http.Request, []byte, error) {
+	if !hasRequestBody(webhook) {
+		request, err := http.NewRequest(webhook.Method, webhook.URL, nil)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to create %s request", webhook.Method)
+		}
+		return request, nil, nil
+	}
+
+	body, contentType, err := encodePayload(webhook)
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := http.NewRequest(webhook.Method, webhook.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to create %s request", webhook.Method)
+	}
+	request.Header.Set("content-type", contentType)
+	return request, body, nil
+}
+
+// webhookAuthHeader returns the headers authenticating a webhook call that needs no token
+func webhookAuthHeader(webhook Webhook, body []byte) (http.Header, error) {
+	switch authType := strings.ToLower(webhook.Auth.AuthType); authType {
+	case hmacAuth:
+		return hmacAuthHeader(webhook.Auth, body, time.Now())
+	case basicAuth, bearerAuth, apiKeyHeaderAuth:
+		return staticAuthHeader(authType, webhook.Auth)
+	default:
+		return nil, nil
+	}
+}
+
+// authorizeWebhookRequest applies the auth types that change the request itself once its headers are final
+func authorizeWebhookRequest(request *http.Request, webhook Webhook, body []byte) error {
+	switch strings.ToLower(webhook.Auth.AuthType) {
+	case apiKeyQueryAuth:
+		return addAPIKeyQuery(request, webhook.Auth)
+	case awsSigV4Auth:
+		return signAWSSigV4(request, webhook.Auth, body, time.Now())
+	default:
+		return nil
+	}
+}
+
+// webhookMetricName builds the per HTTP method metric name, ex. CloudConnector.putWebhook.Success
+func webhookMetricName(method string, call string, name string) string {
+	return "CloudConnector." + strings.ToLower(method) + call + "." + name
+}
+
+func isSuccessStatus(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices
+}
+
 func getWebhookResponse(response *http.Response) (*WebhookResponse, error) {
 	var webhookResponse WebhookResponse
 	response.Body = http.MaxBytesReader(nil, response.Body, responseMaxSize)
@@ -331,20 +432,12 @@ func getWebhookResponse(response *http.Response) (*WebhookResponse, error) {
 	return &webhookResponse, nil
 }
 
-func getHTTPClient(timeout time.Duration, proxy string) (*http.Client, error) {
-	timeOutSec := timeout * time.Second
-	client := &http.Client{
-		Timeout: timeOutSec,
-	}
-	if proxy != "" {
-		proxyURL, parseErr := url.Parse(proxy)
-		if parseErr != nil {
-			return nil, parseErr
-		}
-		transport := http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
-		}
-		client.Transport = &transport
-	}
-	return client, nil
+// getHTTPClient returns the pooled client of the timeout, proxy and client certificate of auth
+func getHTTPClient(timeout time.Duration, proxy string, auth Auth) (*http.Client, error) {
+	return transports.get(timeout*time.Second, proxy, auth)
+}
+
+// getDirectHTTPClient returns the pooled client of the timeout and client certificate of auth that bypasses any proxy
+func getDirectHTTPClient(timeout time.Duration, auth Auth) (*http.Client, error) {
+	return transports.getDirect(timeout*time.Second, auth)
 }
@@ -0,0 +1,259 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownCredential is returned when a credentialref does not name a credential of the store
+var ErrUnknownCredential = errors.New("unknown credential")
+
+// ErrInlineSecretsDisabled is returned when secrets are sent inline while only credential references are allowed
+var ErrInlineSecretsDisabled = errors.New("inline secrets are disabled, use a credentialref instead")
+
+var credentialNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// credentialFileExtension is dropped from the names of the files of a credentials directory
+const credentialFileExtension = ".json"
+
+// credentialStore holds the named credentials webhooks and S3 uploads can reference, see SetCredentialStore
+var credentialStore *CredentialStore
+
+// SetCredentialStore sets the store credential references are resolved from.
+// Without a store inline secrets are allowed and every reference is unknown.
+func SetCredentialStore(store *CredentialStore) {
+	credentialStore = store
+}
+
+// CredentialStore holds named credentials so secrets do not have to be sent with every request.
+// A credential uses the Auth fields, its non empty values replace the ones sent with the request.
+type CredentialStore struct {
+	path               string
+	credentials        map[string]Auth
+	allowInlineSecrets bool
+}
+
+// NewCredentialStore loads the credentials found at path, either a JSON file mapping names to credentials
+// or a directory, such as /run/secrets, holding one credential per file named after the file (minus .json).
+// Files encrypted with the keyring are decrypted. An empty path creates an empty store.
+func NewCredentialStore(path string, allowInlineSecrets bool) (*CredentialStore, error) {
+	store := &CredentialStore{
+		path:               path,
+		credentials:        map[string]Auth{},
+		allowInlineSecrets: allowInlineSecrets,
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open credentials %s", path)
+	}
+
+	if !info.IsDir() {
+		data, err := readSealedFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read credentials %s", path)
+		}
+		var named map[string]Auth
+		if err := json.Unmarshal(data, &named); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal credentials %s", path)
+		}
+		for name, credential := range named {
+			if err := store.add(name, credential); err != nil {
+				return nil, errors.Wrapf(err, "invalid credentials %s", path)
+			}
+		}
+		return store, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read credentials directory %s", path)
+	}
+	for _, file := range files {
+		if file.IsDir() || !isCredentialFile(file.Name()) {
+			continue
+		}
+		data, err := readSealedFile(filepath.Join(path, file.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read credential %s", file.Name())
+		}
+		var credential Auth
+		if err := json.Unmarshal(data, &credential); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal credential %s", file.Name())
+		}
+		if err := store.add(strings.TrimSuffix(file.Name(), credentialFileExtension), credential); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Len returns the number of credentials in the store
+func (store *CredentialStore) Len() int {
+	if store == nil {
+		return 0
+	}
+	return len(store.credentials)
+}
+
+// Reencrypt encrypts the credential files with the primary key.
+// Credentials mounted read only, such as Docker secrets, have to be encrypted with the encrypt flag instead.
+func (store *CredentialStore) Reencrypt() (int, error) {
+	if store == nil || store.path == "" {
+		return 0, nil
+	}
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to open credentials %s", store.path)
+	}
+	if !info.IsDir() {
+		rewritten, err := reencryptFile(store.path)
+		if rewritten {
+			return 1, err
+		}
+		return 0, err
+	}
+	// Credentials are only read at startup, so there is nothing to lock
+	return reencryptDir(store.path, isCredentialFile, &sync.Mutex{})
+}
+
+// isCredentialFile skips the hidden entries mounted secrets come with and the files left by an interrupted re-encryption
+func isCredentialFile(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, tempFileExtension)
+}
+
+func (store *CredentialStore) add(name string, credential Auth) error {
+	if !credentialNamePattern.MatchString(name) {
+		return errors.Errorf("invalid credential name %s", name)
+	}
+	// Credentials cannot reference each other
+	credential.CredentialRef = ""
+	store.credentials[name] = credential
+	return nil
+}
+
+func (store *CredentialStore) lookup(name string) (Auth, error) {
+	if store != nil {
+		if credential, ok := store.credentials[name]; ok {
+			return credential, nil
+		}
+	}
+	return Auth{}, errors.Wrapf(ErrUnknownCredential, "credential %s", name)
+}
+
+func (store *CredentialStore) inlineSecretsAllowed() bool {
+	return store == nil || store.allowInlineSecrets
+}
+
+// CheckWebhookCredentials reports whether the credential referenced by the webhook exists
+// and whether its inline secrets are allowed, so a bad request is rejected before it is queued
+func CheckWebhookCredentials(webhook Webhook) error {
+	_, err := resolveWebhookCredentials(webhook)
+	return err
+}
+
+// resolveWebhookCredentials returns the webhook with the referenced credential merged into its auth settings.
+// The auth credentialref takes precedence over the one of the webhook.
+func resolveWebhookCredentials(webhook Webhook) (Webhook, error) {
+	if !credentialStore.inlineSecretsAllowed() && webhook.Auth.hasSecrets() {
+		return webhook, ErrInlineSecretsDisabled
+	}
+
+	name := webhook.Auth.CredentialRef
+	if name == "" {
+		name = webhook.CredentialRef
+	}
+	if name == "" {
+		return webhook, nil
+	}
+
+	credential, err := credentialStore.lookup(name)
+	if err != nil {
+		return webhook, err
+	}
+	webhook.Auth = webhook.Auth.withCredential(credential)
+	return webhook, nil
+}
+
+// ResolveAwsCredentials returns the connection data with the AWS keys, session token and region of the referenced credential.
+// A credential without keys, holding only a region for instance, leaves the upload to the default credential chain.
+func ResolveAwsCredentials(data AwsConnectionData) (AwsConnectionData, error) {
+	if !credentialStore.inlineSecretsAllowed() && (data.AccessKeyID != "" || data.SecretAccessKey != "" || data.SessionToken != "") {
+		return data, ErrInlineSecretsDisabled
+	}
+	if data.CredentialRef == "" {
+		return data, nil
+	}
+
+	credential, err := credentialStore.lookup(data.CredentialRef)
+	if err != nil {
+		return data, err
+	}
+	if credential.AccessKeyID != "" {
+		data.AccessKeyID = credential.AccessKeyID
+		// A session token only belongs to the keys it was issued with
+		data.SessionToken = credential.SessionToken
+	}
+	if credential.SecretAccessKey != "" {
+		data.SecretAccessKey = credential.SecretAccessKey
+	}
+	if credential.Region != "" {
+		data.Region = credential.Region
+	}
+	if data.AccessKeyID == "" && data.SecretAccessKey == "" {
+		if !awsDefaultCredentials {
+			return data, errors.Errorf("credential %s has no AWS access key", data.CredentialRef)
+		}
+	} else if data.AccessKeyID == "" || data.SecretAccessKey == "" {
+		return data, errors.Errorf("credential %s has an incomplete AWS access key", data.CredentialRef)
+	}
+	return data, nil
+}
+
+// withCredential replaces the auth settings with the non empty values of the credential
+func (auth Auth) withCredential(credential Auth) Auth {
+	merged := reflect.ValueOf(&auth).Elem()
+	values := reflect.ValueOf(credential)
+	for i := 0; i < values.NumField(); i++ {
+		if value := values.Field(i); value.Kind() == reflect.String && value.String() != "" {
+			merged.Field(i).SetString(value.String())
+		}
+	}
+	return auth
+}
+
+// hasSecrets reports whether any of the secret auth settings is set
+func (auth *Auth) hasSecrets() bool {
+	for _, secret := range auth.secrets() {
+		if *secret != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// secrets returns the auth settings that must never be logged or sent inline when inline secrets are disabled
+func (auth *Auth) secrets() []*string {
+	return []*string{
+		&auth.Data, &auth.ClientSecret, &auth.RefreshToken, &auth.Secret,
+		&auth.SecretAccessKey, &auth.SessionToken, &auth.Password, &auth.Token, &auth.APIKey,
+		&auth.PrivateKey, &auth.ServiceAccount, &auth.ClientKey,
+	}
+}
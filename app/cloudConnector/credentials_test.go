@@ -0,0 +1,159 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newTestCredentialStore(t *testing.T, allowInlineSecrets bool) *CredentialStore {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials.json")
+	data := []byte(`{
+		"erp-oauth": {"authtype": "bearer", "token": "erp-token"},
+		"s3-upload": {"accesskeyid": "AKID", "secretaccesskey": "s3cret", "sessiontoken": "session", "region": "us-west-2"},
+		"s3-role": {"region": "eu-central-1"}
+	}`)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCredentialStore(path, allowInlineSecrets)
+	if err != nil {
+		t.Fatalf("Unable to load the credential file: %v", err)
+	}
+	return store
+}
+
+func TestCredentialStoreLoadsSecretsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"erp-oauth":      `{"authtype": "bearer", "token": "erp-token"}`,
+		"hmac-sign.json": `{"authtype": "hmac", "secret": "shared", "credentialref": "erp-oauth"}`,
+		".hidden":        `not json`,
+		"erp-oauth.tmp":  `not json`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewCredentialStore(dir, true)
+	if err != nil {
+		t.Fatalf("Unable to load the secrets directory: %v", err)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 credentials, loaded %d", store.Len())
+	}
+	credential, err := store.lookup("hmac-sign")
+	if err != nil || credential.Secret != "shared" || credential.CredentialRef != "" {
+		t.Errorf("Expected the .json extension to be dropped and references to be ignored, received %v %v", credential, err)
+	}
+
+	if _, err := NewCredentialStore(filepath.Join(dir, "missing"), true); err == nil {
+		t.Error("Expected a missing credentials path to fail")
+	}
+}
+
+func TestResolveWebhookCredentials(t *testing.T) {
+	defer SetCredentialStore(nil)
+	SetCredentialStore(newTestCredentialStore(t, true))
+
+	webhook := Webhook{
+		URL:           "http://local/test",
+		CredentialRef: "erp-oauth",
+		Auth:          Auth{AuthType: "basic", Username: "store"},
+	}
+	resolved, err := resolveWebhookCredentials(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Auth.AuthType != "bearer" || resolved.Auth.Token != "erp-token" || resolved.Auth.Username != "store" {
+		t.Errorf("Expected the credential to be merged into the inline settings, received %v", resolved.Auth)
+	}
+
+	webhook.Auth.CredentialRef = "missing"
+	if _, err := resolveWebhookCredentials(webhook); errors.Cause(err) != ErrUnknownCredential {
+		t.Errorf("Expected the auth reference to take precedence and be unknown, received %v", err)
+	}
+}
+
+func TestInlineSecretsDisabled(t *testing.T) {
+	defer SetCredentialStore(nil)
+	SetCredentialStore(newTestCredentialStore(t, false))
+
+	inline := Webhook{URL: "http://local/test", Auth: Auth{AuthType: "bearer", Token: "inline"}}
+	if err := CheckWebhookCredentials(inline); err != ErrInlineSecretsDisabled {
+		t.Errorf("Expected inline secrets to be rejected, received %v", err)
+	}
+	if err := CheckWebhookCredentials(Webhook{URL: "http://local/test", CredentialRef: "erp-oauth"}); err != nil {
+		t.Errorf("Expected the reference to be accepted, received %v", err)
+	}
+
+	if _, err := ResolveAwsCredentials(AwsConnectionData{AccessKeyID: "AKID", SecretAccessKey: "inline", Bucket: "b"}); err != ErrInlineSecretsDisabled {
+		t.Errorf("Expected inline AWS keys to be rejected, received %v", err)
+	}
+	if _, err := ResolveAwsCredentials(AwsConnectionData{SessionToken: "inline", CredentialRef: "s3-upload", Bucket: "b"}); err != ErrInlineSecretsDisabled {
+		t.Errorf("Expected an inline session token to be rejected, received %v", err)
+	}
+	data, err := ResolveAwsCredentials(AwsConnectionData{CredentialRef: "s3-upload", Bucket: "b"})
+	if err != nil || data.AccessKeyID != "AKID" || data.SecretAccessKey != "s3cret" || data.SessionToken != "session" || data.Region != "us-west-2" {
+		t.Errorf("Expected the AWS keys of the credential, received %+v %v", data, err)
+	}
+
+	defer SetAwsDefaultCredentials(false)
+	SetAwsDefaultCredentials(true)
+	role := AwsConnectionData{CredentialRef: "s3-role", RoleARN: "arn:aws:iam::123456789012:role/uploader", Bucket: "b"}
+	data, err = ResolveAwsCredentials(role)
+	if err != nil || data.AccessKeyID != "" || data.Region != "eu-central-1" || data.RoleARN != role.RoleARN {
+		t.Errorf("Expected the region of the credential and the role to be assumed with the default credentials, received %+v %v", data, err)
+	}
+	SetAwsDefaultCredentials(false)
+	if _, err := ResolveAwsCredentials(role); err == nil {
+		t.Error("Expected a credential without AWS keys to be rejected without the default credential chain")
+	}
+}
+
+func TestDeliverWebhookResolvesCredentials(t *testing.T) {
+	defer SetCredentialStore(nil)
+	SetCredentialStore(newTestCredentialStore(t, true))
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer erp-token" {
+			writer.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer testServer.Close()
+
+	webhook := Webhook{URL: testServer.URL, Method: http.MethodPost, CredentialRef: "erp-oauth"}
+	if _, err := ProcessWebhook(webhook, ""); err != nil {
+		t.Errorf("Expected the stored token to be sent, received %v", err)
+	}
+
+	webhook.CredentialRef = "missing"
+	_, attempts, err := DeliverWebhook(webhook, "")
+	if errors.Cause(err) != ErrUnknownCredential || len(attempts) != 1 {
+		t.Errorf("Expected an unknown credential to fail the delivery, received %v %v", attempts, err)
+	}
+}
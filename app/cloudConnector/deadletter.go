@@ -0,0 +1,175 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/pkg/errors"
+)
+
+// ErrDeadLetterNotFound occurs when no dead letter exists for the requested ID
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// Dead letters are stored under their trace ID, so only IDs that are safe to use as a file name are accepted
+var deadLetterIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// DeadLetterStore keeps failed async deliveries on disk until an operator replays or purges them
+type DeadLetterStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// Redacted returns a copy of the dead letter without the secrets of its webhook, its auth settings and the
+// credential headers of the webhook and its callback, the stored one keeps them for replays
+func (deadLetter DeadLetter) Redacted() DeadLetter {
+	deadLetter.Webhook.Auth = deadLetter.Webhook.Auth.Redacted()
+	deadLetter.Webhook.Header = redactHeader(deadLetter.Webhook.Header)
+	if callback := deadLetter.Webhook.Callback; callback != nil {
+		redactedCallback := *callback
+		redactedCallback.Header = redactHeader(callback.Header)
+		deadLetter.Webhook.Callback = &redactedCallback
+	}
+	return deadLetter
+}
+
+// NewDeadLetterStore opens (or creates) the dead-letter store in dir
+func NewDeadLetterStore(dir string) (*DeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "unable to create dead-letter directory %s", dir)
+	}
+	return &DeadLetterStore{dir: dir}, nil
+}
+
+// Add stores a failed delivery, replacing any previous entry with the same ID
+func (store *DeadLetterStore) Add(deadLetter DeadLetter) error {
+	if !deadLetterIDPattern.MatchString(deadLetter.ID) {
+		return errors.Errorf("invalid dead letter id %q", deadLetter.ID)
+	}
+
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return errors.Wrapf(err, "unable to marshal dead letter")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := writeSealedFile(store.path(deadLetter.ID), data); err != nil {
+		return err
+	}
+	metrics.GetOrRegisterGauge("CloudConnector.DeadLetters.Added", nil).Update(1)
+	return nil
+}
+
+// List returns all dead letters, oldest failure first
+func (store *DeadLetterStore) List() ([]DeadLetter, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read dead-letter directory %s", store.dir)
+	}
+
+	deadLetters := []DeadLetter{}
+	for _, file := range files {
+		if file.IsDir() || !isDeliveryFile(file.Name()) {
+			continue
+		}
+		deadLetter, err := store.read(strings.TrimSuffix(file.Name(), deliveryFileExtension))
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, *deadLetter)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool { return deadLetters[i].FailedAt < deadLetters[j].FailedAt })
+	return deadLetters, nil
+}
+
+// Get returns the dead letter with the given ID
+func (store *DeadLetterStore) Get(id string) (*DeadLetter, error) {
+	if !deadLetterIDPattern.MatchString(id) {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.read(id)
+}
+
+// Remove deletes the dead letter with the given ID
+func (store *DeadLetterStore) Remove(id string) error {
+	if !deadLetterIDPattern.MatchString(id) {
+		return ErrDeadLetterNotFound
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := os.Remove(store.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrDeadLetterNotFound
+		}
+		return errors.Wrapf(err, "unable to remove dead letter %s", id)
+	}
+	return nil
+}
+
+// Purge deletes every dead letter and returns how many were removed
+func (store *DeadLetterStore) Purge() (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read dead-letter directory %s", store.dir)
+	}
+
+	purged := 0
+	for _, file := range files {
+		if file.IsDir() || !isDeliveryFile(file.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store.dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return purged, errors.Wrapf(err, "unable to remove dead letter %s", file.Name())
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Reencrypt encrypts the dead letters with the primary key
+func (store *DeadLetterStore) Reencrypt() (int, error) {
+	return reencryptDir(store.dir, isDeliveryFile, &store.mutex)
+}
+
+func (store *DeadLetterStore) read(id string) (*DeadLetter, error) {
+	data, err := readSealedFile(store.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, errors.Wrapf(err, "unable to read dead letter %s", id)
+	}
+
+	var deadLetter DeadLetter
+	if err := json.Unmarshal(data, &deadLetter); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal dead letter %s", id)
+	}
+	return &deadLetter, nil
+}
+
+func (store *DeadLetterStore) path(id string) string {
+	return filepath.Join(store.dir, id+deliveryFileExtension)
+}
@@ -0,0 +1,90 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDeadLetterStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range []string{"first", "second"} {
+		if err := store.Add(DeadLetter{ID: id, FailedAt: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Add(DeadLetter{ID: "../escape"}); err == nil {
+		t.Error("Expected invalid id to be rejected")
+	}
+
+	deadLetters, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 2 || deadLetters[0].ID != "first" {
+		t.Errorf("Expected 2 dead letters oldest first, received %v", deadLetters)
+	}
+
+	if err := store.Remove("first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("first"); err != ErrDeadLetterNotFound {
+		t.Errorf("Expected removed dead letter to be gone, received %v", err)
+	}
+
+	purged, err := store.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 dead letter purged, received %d", purged)
+	}
+}
+
+func TestDeadLetterRedacted(t *testing.T) {
+	deadLetter := DeadLetter{
+		ID: "trace-1",
+		Webhook: Webhook{
+			Header: http.Header{
+				"Authorization": {"Bearer caller-token"},
+				"X-Api-Key":     {"caller-key"},
+				"Cookie":        {"session=caller"},
+				"X-Store-Id":    {"store-105"},
+			},
+			Auth:     Auth{AuthType: "bearer", Token: "auth-token"},
+			Callback: &Callback{URL: "http://localhost/done", Header: http.Header{"X-Callback-Secret": {"callback-secret"}}},
+		},
+	}
+
+	redactedLetter := deadLetter.Redacted()
+	for _, header := range []http.Header{redactedLetter.Webhook.Header, redactedLetter.Webhook.Callback.Header} {
+		for key, values := range header {
+			if key != "X-Store-Id" && (len(values) != 1 || values[0] != redacted) {
+				t.Errorf("Expected %s to be redacted, received %v", key, values)
+			}
+		}
+	}
+	if redactedLetter.Webhook.Header.Get("X-Store-Id") != "store-105" || redactedLetter.Webhook.Auth.Token != redacted {
+		t.Errorf("Expected only the secrets to be redacted, received %+v", redactedLetter.Webhook)
+	}
+	if deadLetter.Webhook.Header.Get("Authorization") != "Bearer caller-token" || deadLetter.Webhook.Callback.Header.Get("X-Callback-Secret") != "callback-secret" {
+		t.Error("Expected the stored dead letter to keep its secrets for replays")
+	}
+}
@@ -0,0 +1,150 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Payload encodings supported by the webhook API
+const (
+	JSONEncoding   = "json"
+	FormEncoding   = "form"
+	XMLEncoding    = "xml"
+	TextEncoding   = "text"
+	Base64Encoding = "base64"
+)
+
+type payloadEncoder struct {
+	contentType string
+	encode      func(payload interface{}) ([]byte, error)
+}
+
+var payloadEncoders = map[string]payloadEncoder{
+	JSONEncoding:   {contentType: jsonApplication, encode: encodeJSON},
+	FormEncoding:   {contentType: "application/x-www-form-urlencoded", encode: encodeForm},
+	XMLEncoding:    {contentType: "application/xml;charset=utf-8", encode: encodeString},
+	TextEncoding:   {contentType: "text/plain;charset=utf-8", encode: encodeString},
+	Base64Encoding: {contentType: "application/octet-stream", encode: encodeBase64},
+}
+
+// encodePayload encodes the webhook payload and returns it along with its content type.
+// The webhook ContentType, when set, replaces the default content type of the encoding.
+func encodePayload(webhook Webhook) ([]byte, string, error) {
+	encoding := webhook.Encoding
+	if encoding == "" {
+		encoding = JSONEncoding
+	}
+	encoder, ok := payloadEncoders[encoding]
+	if !ok {
+		return nil, "", errors.Errorf("unsupported payload encoding %s", encoding)
+	}
+
+	body, err := encoder.encode(webhook.Payload)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to encode %s payload", encoding)
+	}
+
+	contentType := encoder.contentType
+	if webhook.ContentType != "" {
+		contentType = webhook.ContentType
+	}
+	return body, contentType, nil
+}
+
+func encodeJSON(payload interface{}) ([]byte, error) {
+	return json.Marshal(payload)
+}
+
+// encodeForm url encodes an object of scalars or arrays of scalars. A string is taken as already encoded.
+func encodeForm(payload interface{}) ([]byte, error) {
+	if text, ok := payload.(string); ok {
+		return []byte(text), nil
+	}
+
+	// Round trip through json so any map or struct ends up as generic json values
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.New("form payload must be an object or a string")
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := url.Values{}
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case []interface{}:
+			for _, item := range value {
+				text, err := formValue(key, item)
+				if err != nil {
+					return nil, err
+				}
+				values.Add(key, text)
+			}
+		default:
+			text, err := formValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			values.Add(key, text)
+		}
+	}
+	return []byte(values.Encode()), nil
+}
+
+func formValue(key string, value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case float64, bool:
+		return fmt.Sprint(value), nil
+	default:
+		return "", errors.Errorf("form field %s must be a string, number, boolean or an array of those", key)
+	}
+}
+
+// encodeString sends a string payload, ex. an XML document or plain text, as is
+func encodeString(payload interface{}) ([]byte, error) {
+	text, ok := payload.(string)
+	if !ok {
+		return nil, errors.New("payload must be a string")
+	}
+	return []byte(text), nil
+}
+
+// encodeBase64 decodes a base64 string payload so binary bodies can be sent through the json API
+func encodeBase64(payload interface{}) ([]byte, error) {
+	switch value := payload.(type) {
+	case string:
+		body, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "payload is not valid base64")
+		}
+		return body, nil
+	case []byte:
+		// A []byte payload marshals to base64, so it already holds the decoded body
+		return value, nil
+	default:
+		return nil, errors.New("payload must be a base64 string")
+	}
+}
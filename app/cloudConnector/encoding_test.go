@@ -0,0 +1,86 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"testing"
+)
+
+func TestEncodePayload(t *testing.T) {
+	testCases := []struct {
+		name                string
+		webhook             Webhook
+		expectedBody        string
+		expectedContentType string
+	}{
+		{
+			name:                "json by default",
+			webhook:             Webhook{Payload: map[string]interface{}{"sku": "1234"}},
+			expectedBody:        `{"sku":"1234"}`,
+			expectedContentType: jsonApplication,
+		},
+		{
+			name:                "form object",
+			webhook:             Webhook{Encoding: FormEncoding, Payload: map[string]interface{}{"sku": "12 34", "qty": 2, "tags": []string{"a", "b"}}},
+			expectedBody:        "qty=2&sku=12+34&tags=a&tags=b",
+			expectedContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:                "form already encoded",
+			webhook:             Webhook{Encoding: FormEncoding, Payload: "sku=1234"},
+			expectedBody:        "sku=1234",
+			expectedContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:                "xml",
+			webhook:             Webhook{Encoding: XMLEncoding, Payload: "<sku>1234</sku>"},
+			expectedBody:        "<sku>1234</sku>",
+			expectedContentType: "application/xml;charset=utf-8",
+		},
+		{
+			name:                "text with content type override",
+			webhook:             Webhook{Encoding: TextEncoding, ContentType: "text/csv", Payload: "sku,qty\n1234,2"},
+			expectedBody:        "sku,qty\n1234,2",
+			expectedContentType: "text/csv",
+		},
+		{
+			name:                "base64",
+			webhook:             Webhook{Encoding: Base64Encoding, Payload: "AAEC"},
+			expectedBody:        "\x00\x01\x02",
+			expectedContentType: "application/octet-stream",
+		},
+	}
+
+	for _, testCase := range testCases {
+		body, contentType, err := encodePayload(testCase.webhook)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", testCase.name, err.Error())
+			continue
+		}
+		if string(body) != testCase.expectedBody {
+			t.Errorf("%s: expected body %q, received %q", testCase.name, testCase.expectedBody, string(body))
+		}
+		if contentType != testCase.expectedContentType {
+			t.Errorf("%s: expected content type %s, received %s", testCase.name, testCase.expectedContentType, contentType)
+		}
+	}
+}
+
+func TestEncodePayloadErrors(t *testing.T) {
+	invalid := []Webhook{
+		{Encoding: "yaml", Payload: "sku: 1234"},
+		{Encoding: XMLEncoding, Payload: map[string]interface{}{"sku": "1234"}},
+		{Encoding: Base64Encoding, Payload: "not base64!"},
+		{Encoding: FormEncoding, Payload: map[string]interface{}{"sku": map[string]interface{}{"nested": true}}},
+	}
+
+	for _, webhook := range invalid {
+		if _, _, err := encodePayload(webhook); err == nil {
+			t.Errorf("Expected %s payload %v to be rejected", webhook.Encoding, webhook.Payload)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Hop-by-hop headers only apply to a single connection and are never forwarded (RFC 7230 section 6.1).
+// Content-Length and Host are derived from the request itself.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+	"Host",
+}
+
+// Headers carrying credentials, redacted wherever webhooks are returned by the API.
+// Any header whose name contains one of sensitiveHeaderWords is redacted too, ex. X-Api-Key or X-Auth-Token.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+var sensitiveHeaderWords = []string{"auth", "token", "key", "secret", "password", "session", "signature", "credential", "cookie"}
+
+// mergeHeaders applies the caller headers and then the auth headers to the request.
+// Caller headers replace the headers already on the request, such as the content type of the encoding,
+// while auth headers win over caller headers unless the caller lists them in OverrideHeaders.
+// Hop-by-hop headers from the caller are dropped.
+func mergeHeaders(request *http.Request, webhook Webhook, authHeader http.Header) {
+	callerHeader := withoutHopByHopHeaders(webhook.Header)
+
+	for key, values := range callerHeader {
+		request.Header[key] = append([]string(nil), values...)
+	}
+
+	overrides := make(map[string]bool, len(webhook.OverrideHeaders))
+	for _, key := range webhook.OverrideHeaders {
+		overrides[textproto.CanonicalMIMEHeaderKey(key)] = true
+	}
+
+	for key, values := range authHeader {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if _, ok := callerHeader[key]; ok && overrides[key] {
+			continue
+		}
+		request.Header[key] = append([]string(nil), values...)
+	}
+}
+
+// withoutHopByHopHeaders returns a canonicalized copy of the header without the hop-by-hop headers,
+// including the ones named in the Connection header
+func withoutHopByHopHeaders(header http.Header) http.Header {
+	removed := make(map[string]bool, len(hopByHopHeaders))
+	for _, key := range hopByHopHeaders {
+		removed[key] = true
+	}
+	for key, values := range header {
+		if textproto.CanonicalMIMEHeaderKey(key) != "Connection" {
+			continue
+		}
+		for _, value := range values {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					removed[textproto.CanonicalMIMEHeaderKey(name)] = true
+				}
+			}
+		}
+	}
+
+	cleaned := make(http.Header, len(header))
+	for key, values := range header {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if removed[key] {
+			continue
+		}
+		cleaned[key] = append(cleaned[key], values...)
+	}
+	return cleaned
+}
+
+// redactHeader returns a copy of the header with the values of the sensitive headers replaced
+func redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	redactedHeader := make(http.Header, len(header))
+	for key, values := range header {
+		if isSensitiveHeader(key) {
+			values = []string{redacted}
+		}
+		redactedHeader[key] = append([]string(nil), values...)
+	}
+	return redactedHeader
+}
+
+func isSensitiveHeader(key string) bool {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, sensitive := range sensitiveHeaders {
+		if key == sensitive {
+			return true
+		}
+	}
+	key = strings.ToLower(key)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HMAC signature algorithms
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+const (
+	hmacAuth = "hmac"
+	// defaultSignatureHeader carries the signature when the auth settings name no header
+	defaultSignatureHeader = "X-Signature"
+)
+
+// hmacAuthHeader signs the body with the shared secret of the auth settings.
+// The signature header holds t=<unix seconds>,v1=<hex signature> where the signature covers "<t>.<body>",
+// the scheme used by Stripe, so receivers can reject old timestamps to prevent replays.
+func hmacAuthHeader(auth Auth, body []byte, now time.Time) (http.Header, error) {
+	if auth.Secret == "" {
+		return nil, errors.New("hmac auth requires a secret")
+	}
+
+	var newHash func() hash.Hash
+	switch auth.Algorithm {
+	case "", SHA256:
+		newHash = sha256.New
+	case SHA512:
+		newHash = sha512.New
+	default:
+		return nil, errors.Errorf("unsupported hmac algorithm %s", auth.Algorithm)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(newHash, []byte(auth.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	headerName := auth.HeaderName
+	if headerName == "" {
+		headerName = defaultSignatureHeader
+	}
+	header := http.Header{}
+	header.Set(headerName, "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	return header, nil
+}
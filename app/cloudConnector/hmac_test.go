@@ -0,0 +1,86 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyTestSignature checks a signature header the way a receiver would
+func verifyTestSignature(t *testing.T, header string, secret string, newHash func() hash.Hash, body []byte) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("Unexpected signature format %s", header)
+	}
+
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > time.Minute {
+		t.Errorf("Expected a current timestamp, received %s", timestamp)
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal([]byte(strings.TrimPrefix(parts[1], "v1=")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Errorf("Signature %s does not match the body", header)
+	}
+}
+
+func TestHMACSignedWebhook(t *testing.T) {
+	testCases := []struct {
+		algorithm  string
+		headerName string
+		newHash    func() hash.Hash
+	}{
+		{algorithm: "", headerName: "", newHash: sha256.New},
+		{algorithm: SHA512, headerName: "X-Store-Signature", newHash: sha512.New},
+	}
+
+	for _, testCase := range testCases {
+		expectedHeader := testCase.headerName
+		if expectedHeader == "" {
+			expectedHeader = defaultSignatureHeader
+		}
+
+		testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := ioutil.ReadAll(request.Body)
+			verifyTestSignature(t, request.Header.Get(expectedHeader), "shared-secret", testCase.newHash, body)
+		}))
+
+		webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+		webHook.Payload = map[string]interface{}{"sku": "MS122-38"}
+		webHook.Auth = Auth{AuthType: "HMAC", Secret: "shared-secret", Algorithm: testCase.algorithm, HeaderName: testCase.headerName}
+		// A caller cannot replace the signature without listing it in the override headers
+		webHook.Header = http.Header{expectedHeader: {"t=0,v1=forged"}}
+
+		if _, err := ProcessWebhook(webHook, ""); err != nil {
+			t.Errorf("Signed %q call failed: %s", testCase.algorithm, err.Error())
+		}
+		testMockServer.Close()
+	}
+}
+
+func TestHMACAuthHeaderErrors(t *testing.T) {
+	if _, err := hmacAuthHeader(Auth{AuthType: hmacAuth}, nil, time.Now()); err == nil {
+		t.Error("Expected a missing secret to be rejected")
+	}
+	if _, err := hmacAuthHeader(Auth{AuthType: hmacAuth, Secret: "secret", Algorithm: "md5"}, nil, time.Now()); err == nil {
+		t.Error("Expected an unsupported algorithm to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+)
+
+// Status values of an async delivery job
+const (
+	JobQueued    = "queued"
+	JobInFlight  = "in-flight"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobTracker keeps the status of async deliveries in memory so callers can follow them by job ID.
+// Queued and in-flight jobs are always kept, only the most recent finished jobs are remembered.
+type JobTracker struct {
+	mutex    sync.Mutex
+	capacity int
+	jobs     map[string]*Job
+	finished *list.List
+}
+
+// NewJobTracker creates a tracker remembering up to capacity finished jobs
+func NewJobTracker(capacity int) *JobTracker {
+	return &JobTracker{
+		capacity: capacity,
+		jobs:     make(map[string]*Job),
+		finished: list.New(),
+	}
+}
+
+// Get returns a copy of the job with the given ID
+func (tracker *JobTracker) Get(id string) (Job, bool) {
+	if tracker == nil {
+		return Job{}, false
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	job, ok := tracker.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (tracker *JobTracker) queued(id string, attempts int) {
+	tracker.update(id, func(job *Job) {
+		job.Status = JobQueued
+		job.Attempts = attempts
+	})
+}
+
+func (tracker *JobTracker) inFlight(id string) {
+	tracker.update(id, func(job *Job) {
+		job.Status = JobInFlight
+	})
+}
+
+func (tracker *JobTracker) finish(id string, status string, attempts int, response *WebhookResponse, err error) {
+	tracker.update(id, func(job *Job) {
+		job.Status = status
+		job.Attempts = attempts
+		job.StatusCode = 0
+		job.Error = ""
+		if response != nil {
+			job.StatusCode = response.StatusCode
+		}
+		if err != nil {
+			job.Error = err.Error()
+		}
+	})
+}
+
+func (tracker *JobTracker) update(id string, change func(job *Job)) {
+	if tracker == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	job, ok := tracker.jobs[id]
+	if !ok {
+		job = &Job{ID: id, CreatedAt: helper.UnixMilliNow()}
+		tracker.jobs[id] = job
+	}
+	wasFinished := job.isFinished()
+	change(job)
+	job.UpdatedAt = helper.UnixMilliNow()
+
+	// A replayed job becomes active again and must not be evicted meanwhile
+	if wasFinished && !job.isFinished() {
+		tracker.forget(id)
+	}
+	if !wasFinished && job.isFinished() {
+		tracker.finished.PushBack(id)
+		for tracker.finished.Len() > tracker.capacity {
+			oldest := tracker.finished.Remove(tracker.finished.Front()).(string)
+			delete(tracker.jobs, oldest)
+		}
+	}
+}
+
+func (tracker *JobTracker) forget(id string) {
+	for element := tracker.finished.Front(); element != nil; element = element.Next() {
+		if element.Value.(string) == id {
+			tracker.finished.Remove(element)
+			return
+		}
+	}
+}
+
+func (job *Job) isFinished() bool {
+	return job.Status == JobSucceeded || job.Status == JobFailed
+}
@@ -0,0 +1,66 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestJobTrackerLifecycle(t *testing.T) {
+	tracker := NewJobTracker(10)
+
+	tracker.queued("job", 0)
+	if job, ok := tracker.Get("job"); !ok || job.Status != JobQueued {
+		t.Fatalf("Expected job to be queued, received %v", job)
+	}
+
+	tracker.inFlight("job")
+	if job, _ := tracker.Get("job"); job.Status != JobInFlight {
+		t.Errorf("Expected job to be in-flight, received %s", job.Status)
+	}
+
+	tracker.finish("job", JobSucceeded, 2, &WebhookResponse{StatusCode: http.StatusNoContent}, nil)
+	job, _ := tracker.Get("job")
+	if job.Status != JobSucceeded || job.Attempts != 2 || job.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected succeeded job with 2 attempts and status 204, received %v", job)
+	}
+}
+
+func TestJobTrackerEvictsOnlyFinishedJobs(t *testing.T) {
+	tracker := NewJobTracker(1)
+
+	tracker.queued("active", 0)
+	tracker.finish("first", JobSucceeded, 1, nil, nil)
+	tracker.finish("second", JobFailed, 1, nil, nil)
+
+	if _, ok := tracker.Get("first"); ok {
+		t.Error("Expected oldest finished job to be evicted")
+	}
+	if _, ok := tracker.Get("second"); !ok {
+		t.Error("Expected newest finished job to be kept")
+	}
+	if _, ok := tracker.Get("active"); !ok {
+		t.Error("Expected active job to never be evicted")
+	}
+
+	// Replaying a finished job makes it active again
+	tracker.queued("second", 1)
+	tracker.finish("third", JobSucceeded, 1, nil, nil)
+	tracker.finish("fourth", JobSucceeded, 1, nil, nil)
+	if job, ok := tracker.Get("second"); !ok || job.Status != JobQueued {
+		t.Error("Expected replayed job to be kept while queued")
+	}
+}
+
+func TestNilJobTracker(t *testing.T) {
+	var tracker *JobTracker
+	tracker.queued("job", 0)
+	if _, ok := tracker.Get("job"); ok {
+		t.Error("Expected nil tracker to know no jobs")
+	}
+}
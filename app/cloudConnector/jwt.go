@@ -0,0 +1,208 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	jwtBearerAuth      = "jwt-bearer"
+	serviceAccountAuth = "service-account"
+
+	jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	// Google rejects assertions valid for more than an hour
+	jwtAssertionLifetime = time.Hour
+	// defaultServiceAccountTokenURI is used when the service account key has no token_uri
+	defaultServiceAccountTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// serviceAccountKey holds the fields used from a Google service account JSON key
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// newJWTBearerTokenRequest exchanges a signed JWT assertion for an access token (RFC 7523 section 2.1).
+// A service account key provides the issuer, the private key and the token endpoint of the assertion.
+func newJWTBearerTokenRequest(auth Auth, now time.Time) (*http.Request, error) {
+	serviceAccount := auth.ServiceAccount != ""
+	if serviceAccount {
+		var err error
+		if auth, err = withServiceAccountKey(auth); err != nil {
+			return nil, err
+		}
+	}
+	if auth.PrivateKey == "" || auth.Issuer == "" || auth.Endpoint == "" {
+		return nil, errors.New("jwt-bearer auth requires a private key, an issuer and an endpoint")
+	}
+
+	audience := auth.Audience
+	if audience == "" {
+		audience = auth.Endpoint
+	}
+	claims := map[string]interface{}{
+		"iss": auth.Issuer,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(jwtAssertionLifetime).Unix(),
+		"jti": newJWTID(),
+	}
+
+	form := url.Values{"grant_type": {jwtBearerGrant}}
+	if serviceAccount {
+		// Service accounts request their scopes in the assertion and only set a subject to impersonate a user
+		if auth.Scope != "" {
+			claims["scope"] = auth.Scope
+		}
+		if auth.Subject != "" {
+			claims["sub"] = auth.Subject
+		}
+	} else {
+		claims["sub"] = auth.Subject
+		if auth.Subject == "" {
+			claims["sub"] = auth.Issuer
+		}
+		if auth.Scope != "" {
+			form.Set("scope", auth.Scope)
+		}
+	}
+
+	assertion, err := signJWT(auth.PrivateKey, auth.KeyID, claims)
+	if err != nil {
+		return nil, err
+	}
+	form.Set("assertion", assertion)
+	return newTokenFormRequest(auth, form)
+}
+
+func withServiceAccountKey(auth Auth) (Auth, error) {
+	var key serviceAccountKey
+	if err := json.Unmarshal([]byte(auth.ServiceAccount), &key); err != nil {
+		return auth, errors.Wrapf(err, "unable to parse service account key")
+	}
+	if key.Type != "" && key.Type != "service_account" {
+		return auth, errors.Errorf("unsupported service account key type %s", key.Type)
+	}
+
+	auth.Issuer = key.ClientEmail
+	auth.PrivateKey = key.PrivateKey
+	auth.KeyID = key.PrivateKeyID
+	if auth.Endpoint == "" {
+		auth.Endpoint = key.TokenURI
+	}
+	if auth.Endpoint == "" {
+		auth.Endpoint = defaultServiceAccountTokenURI
+	}
+	return auth, nil
+}
+
+// signJWT signs the claims with an RSA (RS256) or ECDSA P-256 (ES256) private key in PEM format
+func signJWT(privateKeyPEM string, keyID string, claims map[string]interface{}) (string, error) {
+	signer, algorithm, err := parseSigningKey(privateKeyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": algorithm, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to marshal jwt header")
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to marshal jwt claims")
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		signature, err = signES256(key, digest[:])
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to sign jwt")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// signES256 returns the fixed size r || s signature JWS expects instead of the ASN.1 one
+func signES256(key *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rBytes):32], rBytes)
+	copy(signature[64-len(sBytes):], sBytes)
+	return signature, nil
+}
+
+func parseSigningKey(privateKeyPEM string) (crypto.Signer, string, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, "", errors.New("private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to parse private key")
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, "RS256", nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, "", errors.New("only P-256 ECDSA keys are supported")
+		}
+		return key, "ES256", nil
+	default:
+		return nil, "", errors.New("private key must be RSA or ECDSA")
+	}
+}
+
+func newJWTID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
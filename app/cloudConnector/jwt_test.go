@@ -0,0 +1,184 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestSigningKeys(t *testing.T) (string, *rsa.PublicKey, string, *ecdsa.PublicKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER})
+
+	return string(rsaPEM), &rsaKey.PublicKey, string(ecPEM), &ecKey.PublicKey
+}
+
+// verifyTestJWT checks the assertion signature with the public key and returns its header and claims
+func verifyTestJWT(t *testing.T, assertion string, publicKey crypto.PublicKey) (map[string]interface{}, map[string]interface{}) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a JWS compact assertion, received %s", assertion)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("Invalid RS256 signature: %s", err.Error())
+		}
+	case *ecdsa.PublicKey:
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if len(signature) != 64 || !ecdsa.Verify(key, digest[:], r, s) {
+			t.Error("Invalid ES256 signature")
+		}
+	}
+
+	var header, claims map[string]interface{}
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	_ = json.Unmarshal(headerJSON, &header)
+	_ = json.Unmarshal(claimsJSON, &claims)
+	return header, claims
+}
+
+func newTestJWTBearerServer(t *testing.T, publicKey crypto.PublicKey, check func(header, claims map[string]interface{}, form map[string][]string)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.EscapedPath() != "/oauth" {
+			if request.Header.Get("Authorization") != "Bearer assertion-token" {
+				writer.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+
+		if err := request.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if request.PostForm.Get("grant_type") != jwtBearerGrant {
+			t.Errorf("Unexpected grant type %s", request.PostForm.Get("grant_type"))
+		}
+		header, claims := verifyTestJWT(t, request.PostForm.Get("assertion"), publicKey)
+		check(header, claims, request.PostForm)
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"access_token":"assertion-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+}
+
+func TestJWTBearerAuth(t *testing.T) {
+	rsaPEM, rsaPublic, ecPEM, ecPublic := newTestSigningKeys(t)
+
+	testCases := []struct {
+		privateKey string
+		publicKey  crypto.PublicKey
+		algorithm  string
+	}{
+		{privateKey: rsaPEM, publicKey: rsaPublic, algorithm: "RS256"},
+		{privateKey: ecPEM, publicKey: ecPublic, algorithm: "ES256"},
+	}
+
+	for _, testCase := range testCases {
+		accessTokens = newTokenCache(tokenCacheCapacity)
+		var testMockServer *httptest.Server
+		testMockServer = newTestJWTBearerServer(t, testCase.publicKey, func(header, claims map[string]interface{}, form map[string][]string) {
+			if header["alg"] != testCase.algorithm || header["kid"] != "key-1" {
+				t.Errorf("Unexpected assertion header %v", header)
+			}
+			if claims["iss"] != "store-connector" || claims["sub"] != "store-105" || claims["aud"] != testMockServer.URL+"/oauth" {
+				t.Errorf("Unexpected assertion claims %v", claims)
+			}
+			if form["scope"][0] != "inventory" {
+				t.Errorf("Expected the scope to be requested, received %v", form)
+			}
+		})
+
+		webHook := GenerateWebhook(testMockServer.URL, true, http.MethodPost)
+		webHook.Auth = Auth{
+			AuthType:   "jwt-bearer",
+			Endpoint:   testMockServer.URL + "/oauth",
+			PrivateKey: testCase.privateKey,
+			KeyID:      "key-1",
+			Issuer:     "store-connector",
+			Subject:    "store-105",
+			Scope:      "inventory",
+		}
+
+		if _, err := ProcessWebhook(webHook, ""); err != nil {
+			t.Errorf("%s assertion was not accepted: %s", testCase.algorithm, err.Error())
+		}
+		testMockServer.Close()
+	}
+}
+
+func TestServiceAccountAuth(t *testing.T) {
+	accessTokens = newTokenCache(tokenCacheCapacity)
+	rsaPEM, rsaPublic, _, _ := newTestSigningKeys(t)
+
+	var testMockServer *httptest.Server
+	testMockServer = newTestJWTBearerServer(t, rsaPublic, func(header, claims map[string]interface{}, form map[string][]string) {
+		if header["kid"] != "0123abcd" || claims["iss"] != "connector@project.iam.gserviceaccount.com" {
+			t.Errorf("Expected the service account identity, received %v %v", header, claims)
+		}
+		if claims["scope"] != "https://www.googleapis.com/auth/pubsub" || claims["aud"] != testMockServer.URL+"/oauth" {
+			t.Errorf("Expected the scope and token uri in the claims, received %v", claims)
+		}
+		if _, ok := claims["sub"]; ok {
+			t.Errorf("Expected no subject without impersonation, received %v", claims["sub"])
+		}
+	})
+	defer testMockServer.Close()
+
+	serviceAccount, _ := json.Marshal(serviceAccountKey{
+		Type:         "service_account",
+		ClientEmail:  "connector@project.iam.gserviceaccount.com",
+		PrivateKey:   rsaPEM,
+		PrivateKeyID: "0123abcd",
+		TokenURI:     testMockServer.URL + "/oauth",
+	})
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Auth = Auth{
+		AuthType:       "service-account",
+		ServiceAccount: string(serviceAccount),
+		Scope:          "https://www.googleapis.com/auth/pubsub",
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ProcessWebhook(webHook, ""); err != nil {
+			t.Fatalf("Service account call failed: %s", err.Error())
+		}
+	}
+	if accessTokens.len() != 1 {
+		t.Errorf("Expected the service account token to be cached, cache holds %d tokens", accessTokens.len())
+	}
+}
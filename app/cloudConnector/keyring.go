@@ -0,0 +1,298 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// sealedPrefix starts every encrypted file and is followed by the key ID and the base64 nonce and ciphertext
+	sealedPrefix      = "ccenc:v1:"
+	encryptionKeySize = 32
+)
+
+var encryptionKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// ErrUnknownEncryptionKey is returned when data was encrypted with a key missing from the keyring
+var ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+
+// keyring encrypts the secrets the connector persists, see SetKeyring
+var keyring *Keyring
+
+// SetKeyring sets the keys used to encrypt the queue, dead letters, refresh tokens and credentials at rest.
+// Without a keyring they are stored in plain text.
+func SetKeyring(ring *Keyring) {
+	keyring = ring
+}
+
+// Keyring holds the AES-256-GCM keys data is encrypted with.
+// The first key encrypts new data, the other ones decrypt data written before the last key rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring parses keys written as <id>:<base64 32 byte key> entries separated by new lines or commas.
+// The first entry is the primary key, so rotating means adding a new first entry and dropping
+// the old one once the stores have been re-encrypted.
+func NewKeyring(keys string) (*Keyring, error) {
+	ring := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.FieldsFunc(keys, func(r rune) bool { return r == '\n' || r == ',' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || !encryptionKeyIDPattern.MatchString(parts[0]) {
+			return nil, errors.New("encryption keys must be <id>:<base64 key> entries")
+		}
+		id := parts[0]
+		if _, exists := ring.keys[id]; exists {
+			return nil, errors.Errorf("duplicate encryption key %s", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode encryption key %s", id)
+		}
+		if len(key) != encryptionKeySize {
+			return nil, errors.Errorf("encryption key %s must be %d bytes", id, encryptionKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", id)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", id)
+		}
+		if ring.primary == "" {
+			ring.primary = id
+		}
+		ring.keys[id] = aead
+	}
+	if ring.primary == "" {
+		return nil, errors.New("no encryption key found")
+	}
+	return ring, nil
+}
+
+// LoadKeyring reads the keys from the file at path or, when path is empty, from keys.
+// It returns nil when no keys are configured.
+func LoadKeyring(path string, keys string) (*Keyring, error) {
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read encryption keys %s", path)
+		}
+		keys = string(data)
+	}
+	if strings.TrimSpace(keys) == "" {
+		return nil, nil
+	}
+	return NewKeyring(keys)
+}
+
+// seal encrypts data with the primary key
+func (ring *Keyring) seal(data []byte) ([]byte, error) {
+	if ring == nil {
+		return data, nil
+	}
+
+	aead := ring.keys[ring.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrapf(err, "unable to generate nonce")
+	}
+	// The key ID is authenticated so it cannot be swapped
+	sealed := aead.Seal(nonce, nonce, data, []byte(ring.primary))
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return append([]byte(sealedPrefix+ring.primary+":"), encoded...), nil
+}
+
+// open decrypts data sealed with any key of the keyring, data that was never encrypted is returned as is
+func (ring *Keyring) open(data []byte) ([]byte, error) {
+	id, encoded, ok := parseSealed(data)
+	if !ok {
+		return data, nil
+	}
+	if ring == nil {
+		return nil, errors.New("data is encrypted but no encryption key is configured")
+	}
+	aead, ok := ring.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownEncryptionKey, "key %s", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted data")
+	}
+	data, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt data with key %s", id)
+	}
+	return data, nil
+}
+
+// isCurrent reports whether data is already encrypted with the primary key
+func (ring *Keyring) isCurrent(data []byte) bool {
+	id, _, ok := parseSealed(data)
+	return ring == nil || (ok && id == ring.primary)
+}
+
+func parseSealed(data []byte) (string, []byte, bool) {
+	if !bytes.HasPrefix(data, []byte(sealedPrefix)) {
+		return "", nil, false
+	}
+	rest := data[len(sealedPrefix):]
+	separator := bytes.IndexByte(rest, ':')
+	if separator < 0 {
+		return "", nil, false
+	}
+	return string(rest[:separator]), bytes.TrimSpace(rest[separator+1:]), true
+}
+
+// writeSealedFile encrypts data with the primary key before writing it atomically
+func writeSealedFile(path string, data []byte) error {
+	sealed, err := keyring.seal(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encrypt %s", path)
+	}
+	return writeFileAtomic(path, sealed)
+}
+
+// readSealedFile reads a file written by writeSealedFile, errors reading the file are returned as is
+func readSealedFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = keyring.open(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt %s", path)
+	}
+	return data, nil
+}
+
+// reencryptFile encrypts the file with the primary key unless it already is, a missing file is skipped
+func reencryptFile(path string) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "unable to read %s", path)
+	}
+	if keyring.isCurrent(data) {
+		return false, nil
+	}
+	data, err = keyring.open(data)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to decrypt %s", path)
+	}
+	if err := writeSealedFile(path, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reencryptDir re-encrypts the files of dir accepted by match, holding lock while a file is rewritten
+// so the owning store never sees its changes overwritten
+func reencryptDir(dir string, match func(name string) bool, lock sync.Locker) (int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read directory %s", dir)
+	}
+
+	reencrypted := 0
+	for _, file := range files {
+		if file.IsDir() || !match(file.Name()) {
+			continue
+		}
+		lock.Lock()
+		rewritten, err := reencryptFile(filepath.Join(dir, file.Name()))
+		lock.Unlock()
+		if err != nil {
+			return reencrypted, err
+		}
+		if rewritten {
+			reencrypted++
+		}
+	}
+	return reencrypted, nil
+}
+
+// EncryptFile encrypts the file in place with the primary key, ex. to prepare a credentials file
+func EncryptFile(path string) error {
+	if keyring == nil {
+		return errors.New("no encryption key is configured")
+	}
+	_, err := reencryptFile(path)
+	return err
+}
+
+// Reencrypter is implemented by the stores persisting secrets.
+// Reencrypt rewrites everything not yet encrypted with the primary key and returns how many entries it rewrote.
+type Reencrypter interface {
+	Reencrypt() (int, error)
+}
+
+// ReencryptInBackground brings the stores to the primary key, encrypting plain text entries
+// and the ones written with a previous key, without holding up the service
+func ReencryptInBackground(stores ...Reencrypter) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	if keyring == nil {
+		return &wg
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mReencrypted := metrics.GetOrRegisterGauge("CloudConnector.Keyring.Reencrypted", nil)
+		mError := metrics.GetOrRegisterGauge("CloudConnector.Keyring.Reencrypt-Error", nil)
+
+		for _, store := range stores {
+			reencrypted, err := store.Reencrypt()
+			mReencrypted.Update(int64(reencrypted))
+			if err != nil {
+				mError.Update(1)
+				log.WithFields(log.Fields{
+					"Method":      "ReencryptInBackground",
+					"Store":       fmt.Sprintf("%T", store),
+					"Reencrypted": reencrypted,
+					"Error":       err.Error(),
+				}).Error("Unable to re-encrypt store")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"Method":      "ReencryptInBackground",
+				"Store":       fmt.Sprintf("%T", store),
+				"Reencrypted": reencrypted,
+			}).Info("Store encrypted with the primary key")
+		}
+	}()
+	return &wg
+}
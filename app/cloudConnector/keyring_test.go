@@ -0,0 +1,175 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newTestKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, encryptionKeySize))
+}
+
+func newTestKeyring(t *testing.T, keys string) *Keyring {
+	ring, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatalf("Unable to create keyring: %v", err)
+	}
+	return ring
+}
+
+func TestKeyringSealAndOpen(t *testing.T) {
+	ring := newTestKeyring(t, "k1:"+newTestKey(1))
+
+	sealed, err := ring.seal([]byte(`{"token":"s3cret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("s3cret")) || !strings.HasPrefix(string(sealed), sealedPrefix+"k1:") {
+		t.Errorf("Expected the data to be encrypted under k1, received %s", sealed)
+	}
+	data, err := ring.open(sealed)
+	if err != nil || string(data) != `{"token":"s3cret"}` {
+		t.Errorf("Expected the data back, received %s %v", data, err)
+	}
+
+	plain, err := ring.open([]byte(`{"token":"plain"}`))
+	if err != nil || string(plain) != `{"token":"plain"}` {
+		t.Errorf("Expected plain text data to be readable, received %s %v", plain, err)
+	}
+
+	other := newTestKeyring(t, "k2:"+newTestKey(2))
+	if _, err := other.open(sealed); errors.Cause(err) != ErrUnknownEncryptionKey {
+		t.Errorf("Expected an unknown key error, received %v", err)
+	}
+	if _, err := (*Keyring)(nil).open(sealed); err == nil {
+		t.Error("Expected encrypted data to need a key")
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := ring.open(tampered); err == nil {
+		t.Error("Expected tampered data to be rejected")
+	}
+}
+
+func TestNewKeyringValidatesKeys(t *testing.T) {
+	invalid := []string{
+		"",
+		"k1",
+		"k1:not-base64!",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:" + newTestKey(1) + ",k1:" + newTestKey(2),
+		"bad/id:" + newTestKey(1),
+	}
+	for _, keys := range invalid {
+		if _, err := NewKeyring(keys); err == nil {
+			t.Errorf("Expected %q to be rejected", keys)
+		}
+	}
+
+	ring := newTestKeyring(t, "# rotated 2019-06\nk2:"+newTestKey(2)+"\nk1:"+newTestKey(1)+"\n")
+	if ring.primary != "k2" || len(ring.keys) != 2 {
+		t.Errorf("Expected k2 to be the primary of 2 keys, received %s of %d", ring.primary, len(ring.keys))
+	}
+}
+
+func TestReencryptStores(t *testing.T) {
+	defer SetKeyring(nil)
+
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Written in plain text before encryption was enabled
+	deadLetters, err := NewDeadLetterStore(filepath.Join(dir, "deadletters"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deadLetters.Add(DeadLetter{ID: "plain", Webhook: Webhook{Auth: Auth{Token: "s3cret"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written under the key that is being rotated out
+	SetKeyring(newTestKeyring(t, "k1:"+newTestKey(1)))
+	queue, err := NewDeliveryQueue(filepath.Join(dir, "queue"), DeliveryQueueSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Enqueue("old", Webhook{URL: "http://local/test", Auth: Auth{Token: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	SetKeyring(newTestKeyring(t, "k2:"+newTestKey(2)+",k1:"+newTestKey(1)))
+	ReencryptInBackground(queue, deadLetters).Wait()
+
+	for _, store := range []string{"queue", "deadletters"} {
+		files, _ := ioutil.ReadDir(filepath.Join(dir, store))
+		for _, file := range files {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, store, file.Name()))
+			if !strings.HasPrefix(string(data), sealedPrefix+"k2:") {
+				t.Errorf("Expected %s/%s to be encrypted with k2, received %s", store, file.Name(), data)
+			}
+		}
+	}
+
+	// The old key can now be dropped
+	SetKeyring(newTestKeyring(t, "k2:"+newTestKey(2)))
+	deadLetter, err := deadLetters.Get("plain")
+	if err != nil || deadLetter.Webhook.Auth.Token != "s3cret" {
+		t.Errorf("Expected the dead letter to be readable, received %v %v", deadLetter, err)
+	}
+	delivery, err := queue.head()
+	if err != nil || delivery.ID != "old" {
+		t.Errorf("Expected the delivery to be readable, received %v %v", delivery, err)
+	}
+}
+
+func TestEncryptedCredentialFile(t *testing.T) {
+	defer SetKeyring(nil)
+
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, []byte(`{"erp": {"authtype": "bearer", "token": "s3cret"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(path); err == nil {
+		t.Error("Expected encrypting without a key to fail")
+	}
+
+	SetKeyring(newTestKeyring(t, "k1:"+newTestKey(1)))
+	if err := EncryptFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Contains(data, []byte("s3cret")) {
+		t.Error("Expected the credential file to be encrypted")
+	}
+
+	store, err := NewCredentialStore(path, true)
+	if err != nil {
+		t.Fatalf("Unable to load the encrypted credentials: %v", err)
+	}
+	if credential, err := store.lookup("erp"); err != nil || credential.Token != "s3cret" {
+		t.Errorf("Expected the decrypted credential, received %v %v", credential, err)
+	}
+}
@@ -10,13 +10,35 @@ import (
 	"net/http"
 )
 
-// AwsConnectionData contains headers, and payload
+// AwsConnectionData contains headers, and payload.
+// Without access keys the SDK default credential chain is used when enabled, see SetAwsDefaultCredentials.
+// RoleARN is assumed through STS on top of either, with the optional ExternalID and RoleSessionName.
+// CredentialRef names a stored credential providing the access keys and region instead of sending them.
+// KeyTemplate names the uploaded object, see ObjectKey.
+// Endpoint, ForcePathStyle and DisableSSL target S3 compatible object stores such as MinIO or Ceph.
+// ContentType, Metadata, Tags, StorageClass, ServerSideEncryption, SSEKMSKeyID and CacheControl are set on the uploaded object.
 type AwsConnectionData struct {
-	AccessKeyID     string      `json:"accesskeyid" valid:"required"`
-	SecretAccessKey string      `json:"secretaccesskey" valid:"required"`
-	Region          string      `json:"region" valid:"required"`
-	Bucket          string      `json:"bucket" valid:"required"`
-	Payload         interface{} `json:"payload" valid:"optional"`
+	AccessKeyID          string            `json:"accesskeyid" valid:"optional"`
+	SecretAccessKey      string            `json:"secretaccesskey" valid:"optional"`
+	SessionToken         string            `json:"sessiontoken,omitempty" valid:"optional"`
+	RoleARN              string            `json:"rolearn,omitempty" valid:"optional"`
+	ExternalID           string            `json:"externalid,omitempty" valid:"optional"`
+	RoleSessionName      string            `json:"rolesessionname,omitempty" valid:"optional"`
+	Region               string            `json:"region" valid:"required"`
+	Bucket               string            `json:"bucket" valid:"required"`
+	Payload              interface{}       `json:"payload" valid:"optional"`
+	CredentialRef        string            `json:"credentialref,omitempty" valid:"optional"`
+	KeyTemplate          string            `json:"keytemplate,omitempty" valid:"optional"`
+	Endpoint             string            `json:"endpoint,omitempty" valid:"optional"`
+	ForcePathStyle       bool              `json:"forcepathstyle,omitempty" valid:"optional"`
+	DisableSSL           bool              `json:"disablessl,omitempty" valid:"optional"`
+	ContentType          string            `json:"contenttype,omitempty" valid:"optional"`
+	Metadata             map[string]string `json:"metadata,omitempty" valid:"optional"`
+	Tags                 map[string]string `json:"tags,omitempty" valid:"optional"`
+	StorageClass         string            `json:"storageclass,omitempty" valid:"optional"`
+	ServerSideEncryption string            `json:"serversideencryption,omitempty" valid:"optional"`
+	SSEKMSKeyID          string            `json:"ssekmskeyid,omitempty" valid:"optional"`
+	CacheControl         string            `json:"cachecontrol,omitempty" valid:"optional"`
 }
 
 type WebhookResponse struct {
@@ -25,21 +47,128 @@ type WebhookResponse struct {
 	Body       []byte      `json:"body" valid:"optional"`
 }
 
-// Webhook contains webhook address, headers, method, authentication method, and payload
+// DeliveryAttempt records the outcome of a single call to a webhook
+type DeliveryAttempt struct {
+	Timestamp  int64  `json:"timestamp"`
+	StatusCode int    `json:"statuscode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DeadLetter is an async webhook delivery that could not be completed
+type DeadLetter struct {
+	ID               string            `json:"id"`
+	Webhook          Webhook           `json:"webhook"`
+	Attempts         []DeliveryAttempt `json:"attempts"`
+	LastStatusCode   int               `json:"laststatuscode"`
+	LastResponseBody string            `json:"lastresponsebody"`
+	EnqueuedAt       int64             `json:"enqueuedat"`
+	FailedAt         int64             `json:"failedat"`
+}
+
+// Job reports the progress of an async webhook delivery.
+// StatusCode is the status of the last WebhookResponse, it is 0 when the destination never answered.
+type Job struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"statuscode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  int64  `json:"createdat"`
+	UpdatedAt  int64  `json:"updatedat"`
+}
+
+// Webhook contains webhook address, headers, method, authentication method, and payload.
+// Encoding is one of json (default), form, xml, text or base64 and ContentType replaces its default content type.
+// OverrideHeaders lists the caller headers that replace the auth derived headers, ex. Authorization.
+// CredentialRef names a stored credential merged into Auth, unless Auth references one itself.
 type Webhook struct {
-	Header  http.Header `json:"header" valid:"optional"`
-	Method  string      `json:"method" valid:"required"`
-	URL     string      `json:"url" valid:"required,url"`
-	Auth    Auth        `json:"auth" valid:"optional"`
-	Payload interface{} `json:"payload" valid:"optional"`
-	IsAsync bool        `json:"isasync" valid:"required"`
+	Header          http.Header  `json:"header" valid:"optional"`
+	OverrideHeaders []string     `json:"overrideheaders" valid:"optional"`
+	Method          string       `json:"method" valid:"required"`
+	URL             string       `json:"url" valid:"required,url"`
+	Auth            Auth         `json:"auth" valid:"optional"`
+	Payload         interface{}  `json:"payload" valid:"optional"`
+	Encoding        string       `json:"encoding,omitempty" valid:"optional"`
+	ContentType     string       `json:"contenttype,omitempty" valid:"optional"`
+	IsAsync         bool         `json:"isasync" valid:"required"`
+	Retry           *RetryPolicy `json:"retry" valid:"optional"`
+	Callback        *Callback    `json:"callback" valid:"optional"`
+	CredentialRef   string       `json:"credentialref,omitempty" valid:"optional"`
+}
+
+// Callback is a local endpoint notified with the outcome of an async webhook call
+type Callback struct {
+	URL         string      `json:"url" valid:"required,url"`
+	Header      http.Header `json:"header" valid:"optional"`
+	MaxBodySize int         `json:"maxbodysize" valid:"optional"`
+}
+
+// CallbackOutcome is posted to the callback URL once an async webhook call succeeded or failed for good.
+// StatusCode, Header and Body come from the last WebhookResponse, Body is cut to the callback MaxBodySize.
+type CallbackOutcome struct {
+	TraceID    string      `json:"traceid"`
+	Status     string      `json:"status"`
+	Attempts   int         `json:"attempts"`
+	StatusCode int         `json:"statuscode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Truncated  bool        `json:"truncated"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// RetryPolicy controls how a failed webhook call is retried.
+// Backoff values are in milliseconds.
+type RetryPolicy struct {
+	MaxAttempts          int      `json:"maxattempts" valid:"optional"`
+	InitialBackoff       int      `json:"initialbackoff" valid:"optional"`
+	MaxBackoff           int      `json:"maxbackoff" valid:"optional"`
+	Jitter               float64  `json:"jitter" valid:"optional"`
+	RetryableStatusCodes []int    `json:"retryablestatuscodes" valid:"optional"`
+	RetryableErrors      []string `json:"retryableerrors" valid:"optional"`
 }
 
-// Auth contains the type and the endpoint of authentication
+// Auth contains the type and the endpoint of authentication.
+// For OAuth2 a RefreshToken is exchanged with the RFC 6749 refresh token grant and a ClientID alone selects the
+// client credentials grant, otherwise Data is sent as the Authorization header of the token request.
+// For HMAC the payload is signed with Secret using Algorithm (sha256 or sha512) into the HeaderName header.
+// For aws-sigv4 the request is signed for the Region and Service with the AWS credentials.
+// Basic sends Username and Password, bearer sends Token and apikey-header and apikey-query send APIKey
+// in the HeaderName header or the ParamName query parameter.
+// For jwt-bearer an assertion from Issuer about Subject is signed with PrivateKey (RSA or P-256 ECDSA PEM) and exchanged
+// at Endpoint (RFC 7523), service-account does the same with the issuer, key and endpoint of a Google service account key.
+// ClientCert and ClientKey (PEM) are presented to the webhook and token endpoints requiring mutual TLS, with any auth type.
+// CredentialRef names a stored credential whose non empty settings replace the ones sent inline.
 type Auth struct {
-	AuthType string `json:"authtype" valid:"length(0|1024)"`
-	Endpoint string `json:"endpoint" valid:"url"`
-	Data     string `json:"data" valid:"length(0|1024)"`
+	AuthType         string `json:"authtype" valid:"length(0|1024)"`
+	Endpoint         string `json:"endpoint" valid:"url"`
+	Data             string `json:"data" valid:"length(0|1024)"`
+	ClientID         string `json:"clientid" valid:"length(0|1024)"`
+	ClientSecret     string `json:"clientsecret" valid:"length(0|1024)"`
+	Scope            string `json:"scope" valid:"length(0|1024)"`
+	Audience         string `json:"audience" valid:"length(0|1024)"`
+	ClientAuthMethod string `json:"clientauthmethod,omitempty" valid:"optional"`
+	RefreshToken     string `json:"refreshtoken" valid:"length(0|4096)"`
+	Secret           string `json:"secret" valid:"length(0|1024)"`
+	Algorithm        string `json:"algorithm,omitempty" valid:"optional"`
+	HeaderName       string `json:"headername,omitempty" valid:"optional"`
+	Region           string `json:"region" valid:"length(0|1024)"`
+	Service          string `json:"service" valid:"length(0|1024)"`
+	AccessKeyID      string `json:"accesskeyid" valid:"length(0|1024)"`
+	SecretAccessKey  string `json:"secretaccesskey" valid:"length(0|1024)"`
+	SessionToken     string `json:"sessiontoken" valid:"length(0|4096)"`
+	Username         string `json:"username" valid:"length(0|1024)"`
+	Password         string `json:"password" valid:"length(0|1024)"`
+	Token            string `json:"token" valid:"length(0|4096)"`
+	APIKey           string `json:"apikey" valid:"length(0|1024)"`
+	ParamName        string `json:"paramname,omitempty" valid:"optional"`
+	PrivateKey       string `json:"privatekey" valid:"length(0|16384)"`
+	KeyID            string `json:"keyid" valid:"length(0|1024)"`
+	Issuer           string `json:"issuer" valid:"length(0|1024)"`
+	Subject          string `json:"subject" valid:"length(0|1024)"`
+	ServiceAccount   string `json:"serviceaccount" valid:"length(0|16384)"`
+	ClientCert       string `json:"clientcert" valid:"length(0|16384)"`
+	ClientKey        string `json:"clientkey" valid:"length(0|16384)"`
+	CredentialRef    string `json:"credentialref,omitempty" valid:"optional"`
 }
 
 // WebhookSchema defines Webhook schema for input validation
@@ -63,6 +192,294 @@ const WebhookSchema = `
 									"type": "string",
 									"minLength": 0,
 									"maxLength": 1024
+							},
+							"clientid": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"clientsecret": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"scope": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"audience": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"refreshtoken": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 4096
+							},
+							"clientauthmethod": {
+									"type": "string",
+									"enum": ["client_secret_basic", "client_secret_post"]
+							},
+							"secret": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"algorithm": {
+									"type": "string",
+									"enum": ["sha256", "sha512"]
+							},
+							"headername": {
+									"type": "string",
+									"pattern": "^[A-Za-z0-9!#$%&'*+.^_|~-]+$",
+									"maxLength": 256
+							},
+							"region": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"service": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"accesskeyid": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"secretaccesskey": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"sessiontoken": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 4096
+							},
+							"username": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"password": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"token": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 4096
+							},
+							"apikey": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"paramname": {
+									"type": "string",
+									"pattern": "^[A-Za-z0-9._~-]+$",
+									"maxLength": 256
+							},
+							"privatekey": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 16384
+							},
+							"keyid": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"issuer": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"subject": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 1024
+							},
+							"serviceaccount": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 16384
+							},
+							"clientcert": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 16384
+							},
+							"clientkey": {
+									"type": "string",
+									"minLength": 0,
+									"maxLength": 16384
+							},
+							"credentialref": {
+									"type": "string",
+									"pattern": "^[A-Za-z0-9_.-]{1,128}$"
+							}
+					},
+					"additionalProperties": false,
+					"type": "object"
+			},
+			"AuthSecrets": {
+					"dependencies": {
+						"clientcert": ["clientkey"],
+						"clientkey": ["clientcert"]
+					},
+					"allOf": [
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Hh][Mm][Aa][Cc]$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["secret"],
+								"properties": {"secret": {"minLength": 1}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Aa][Ww][Ss]-[Ss][Ii][Gg][Vv]4$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["region", "service", "accesskeyid", "secretaccesskey"],
+								"properties": {
+									"region": {"minLength": 1},
+									"service": {"minLength": 1},
+									"accesskeyid": {"minLength": 1},
+									"secretaccesskey": {"minLength": 1}
+								}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Bb][Aa][Ss][Ii][Cc]$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["username"],
+								"properties": {"username": {"minLength": 1}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Bb][Ee][Aa][Rr][Ee][Rr]$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["token"],
+								"properties": {"token": {"minLength": 1}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Aa][Pp][Ii][Kk][Ee][Yy]-([Hh][Ee][Aa][Dd][Ee][Rr]|[Qq][Uu][Ee][Rr][Yy])$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["apikey"],
+								"properties": {"apikey": {"minLength": 1}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Jj][Ww][Tt]-[Bb][Ee][Aa][Rr][Ee][Rr]$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["privatekey", "issuer", "endpoint"],
+								"properties": {
+									"privatekey": {"minLength": 1},
+									"issuer": {"minLength": 1},
+									"endpoint": {"minLength": 1}
+								}
+							}
+						},
+						{
+							"if": {
+								"properties": {"authtype": {"pattern": "^[Ss][Ee][Rr][Vv][Ii][Cc][Ee]-[Aa][Cc][Cc][Oo][Uu][Nn][Tt]$"}},
+								"required": ["authtype"]
+							},
+							"then": {
+								"required": ["serviceaccount"],
+								"properties": {"serviceaccount": {"minLength": 1}}
+							}
+						}
+					],
+					"type": "object"
+			},
+			"RetryPolicy": {
+					"properties": {
+							"maxattempts": {
+									"type": "integer",
+									"minimum": 1,
+									"maximum": 100
+							},
+							"initialbackoff": {
+									"type": "integer",
+									"minimum": 0,
+									"maximum": 3600000
+							},
+							"maxbackoff": {
+									"type": "integer",
+									"minimum": 0,
+									"maximum": 3600000
+							},
+							"jitter": {
+									"type": "number",
+									"minimum": 0,
+									"maximum": 1
+							},
+							"retryablestatuscodes": {
+									"type": "array",
+									"maxItems": 100,
+									"items": {
+										"type": "integer",
+										"minimum": 100,
+										"maximum": 599
+									}
+							},
+							"retryableerrors": {
+									"type": "array",
+									"maxItems": 10,
+									"items": {
+										"type": "string",
+										"enum": ["timeout", "connectionrefused", "connectionreset", "dns"]
+									}
+							}
+					},
+					"additionalProperties": false,
+					"type": "object"
+			},
+			"Callback": {
+					"required": [
+							"url"
+					],
+					"properties": {
+							"url": {
+									"type": "string",
+									"format": "uri",
+									"maxLength": 1024
+							},
+							"header": {
+								"oneOf": [
+									{"type": "null"},
+									{"$ref": "#/definitions/Header"}
+								]
+							},
+							"maxbodysize": {
+									"type": "integer",
+									"minimum": 0,
+									"maximum": 16777216
 							}
 					},
 					"additionalProperties": false,
@@ -102,14 +519,79 @@ const WebhookSchema = `
 									{"$ref": "#/definitions/Header"}
 								]
 							},
+							"overrideheaders": {
+								"type": ["array", "null"],
+								"maxItems": 100,
+								"items": {
+									"type": "string",
+									"minLength": 1,
+									"maxLength": 256
+								}
+							},
 							"method": {
 								"type": ["string", "null"],
-								"enum": ["POST", "GET"]
+								"enum": ["POST", "GET", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"]
+							},
+							"encoding": {
+								"type": "string",
+								"enum": ["json", "form", "xml", "text", "base64"]
+							},
+							"contenttype": {
+								"type": "string",
+								"pattern": "^[A-Za-z0-9!#$&^_.+-]+/[A-Za-z0-9!#$&^_.+-]+( *;.*)?$",
+								"maxLength": 256
 							},
 							"isasync": {
 								"type": "boolean"
+							},
+							"retry": {
+								"oneOf": [
+									{"type": "null"},
+									{"$ref": "#/definitions/RetryPolicy"}
+								]
+							},
+							"callback": {
+								"oneOf": [
+									{"type": "null"},
+									{"$ref": "#/definitions/Callback"}
+								]
+							},
+							"credentialref": {
+								"type": "string",
+								"pattern": "^[A-Za-z0-9_.-]{1,128}$"
 							}
 					},
+					"allOf": [
+						{
+							"if": {
+								"anyOf": [
+									{"required": ["credentialref"]},
+									{"properties": {"auth": {"required": ["credentialref"]}}, "required": ["auth"]}
+								]
+							},
+							"else": {
+								"properties": {"auth": {"$ref": "#/definitions/AuthSecrets"}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"encoding": {"enum": ["xml", "text", "base64"]}},
+								"required": ["encoding"]
+							},
+							"then": {
+								"properties": {"payload": {"type": ["string", "null"]}}
+							}
+						},
+						{
+							"if": {
+								"properties": {"encoding": {"const": "form"}},
+								"required": ["encoding"]
+							},
+							"then": {
+								"properties": {"payload": {"type": ["object", "string", "null"]}}
+							}
+						}
+					],
 					"additionalProperties": false,
 					"type": "object"
 			}
@@ -124,10 +606,24 @@ const AwsConnectionDataSchema = `
 	"definitions": {
 			"AwsConnectionData" : {
 				"required": [
-					"accesskeyid",
-					"secretaccesskey",
 					"bucket"
 				],
+				"dependencies": {
+					"accesskeyid": ["secretaccesskey"],
+					"secretaccesskey": ["accesskeyid"],
+					"sessiontoken": ["accesskeyid"],
+					"externalid": ["rolearn"],
+					"rolesessionname": ["rolearn"]
+				},
+				"if": {
+					"required": ["ssekmskeyid"]
+				},
+				"then": {
+					"required": ["serversideencryption"],
+					"properties": {
+						"serversideencryption": {"enum": ["aws:kms"]}
+					}
+				},
 				"properties": {
 					"accesskeyid": {
 						"type": "string",
@@ -139,6 +635,26 @@ const AwsConnectionDataSchema = `
 						"minLength": 1,
 						"maxLength": 1024
 					},
+					"sessiontoken": {
+						"type": "string",
+						"minLength": 1,
+						"maxLength": 4096
+					},
+					"rolearn": {
+						"type": "string",
+						"pattern": "^arn:[^:]+:iam:",
+						"maxLength": 2048
+					},
+					"externalid": {
+						"type": "string",
+						"pattern": "^[A-Za-z0-9+=,.@:/-]+$",
+						"minLength": 2,
+						"maxLength": 1224
+					},
+					"rolesessionname": {
+						"type": "string",
+						"pattern": "^[A-Za-z0-9_+=,.@-]{2,64}$"
+					},
 					"bucket": {
 						"type": "string",
 						"minLength": 1,
@@ -149,7 +665,73 @@ const AwsConnectionDataSchema = `
 						"minLength": 1,
 						"maxLength": 1024
 					},
-					"payload": {}
+					"payload": {},
+					"credentialref": {
+						"type": "string",
+						"pattern": "^[A-Za-z0-9_.-]{1,128}$"
+					},
+					"keytemplate": {
+						"type": "string",
+						"minLength": 1,
+						"maxLength": 1024
+					},
+					"endpoint": {
+						"type": "string",
+						"minLength": 1,
+						"maxLength": 1024
+					},
+					"forcepathstyle": {
+						"type": "boolean"
+					},
+					"disablessl": {
+						"type": "boolean"
+					},
+					"contenttype": {
+						"type": "string",
+						"pattern": "^[A-Za-z0-9!#$&^_.+-]+/[A-Za-z0-9!#$&^_.+-]+( *;.*)?$",
+						"maxLength": 256
+					},
+					"metadata": {
+						"type": "object",
+						"patternProperties": {
+							"^[A-Za-z0-9_-]{1,128}$": {
+								"type": "string",
+								"pattern": "^[ -~]*$",
+								"maxLength": 2048
+							}
+						},
+						"additionalProperties": false
+					},
+					"tags": {
+						"type": "object",
+						"maxProperties": 10,
+						"patternProperties": {
+							"^[A-Za-z0-9 +=._:/@-]{1,128}$": {
+								"type": "string",
+								"pattern": "^[A-Za-z0-9 +=._:/@-]*$",
+								"maxLength": 256
+							}
+						},
+						"additionalProperties": false
+					},
+					"storageclass": {
+						"type": "string",
+						"enum": ["STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE"]
+					},
+					"serversideencryption": {
+						"type": "string",
+						"enum": ["AES256", "aws:kms"]
+					},
+					"ssekmskeyid": {
+						"type": "string",
+						"minLength": 1,
+						"maxLength": 2048
+					},
+					"cachecontrol": {
+						"type": "string",
+						"minLength": 1,
+						"maxLength": 1024
+					}
 				},
 				"additionalProperties": false,
 				"type": "object"
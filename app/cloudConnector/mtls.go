@@ -0,0 +1,30 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// clientTLSConfig returns the TLS settings presenting the client certificate of the auth settings
+// to endpoints requiring mutual TLS, nil when no certificate is set
+func clientTLSConfig(auth Auth) (*tls.Config, error) {
+	if auth.ClientCert == "" && auth.ClientKey == "" {
+		return nil, nil
+	}
+	if auth.ClientCert == "" || auth.ClientKey == "" {
+		return nil, errors.New("mutual TLS requires both a client certificate and its key")
+	}
+
+	certificate, err := tls.X509KeyPair([]byte(auth.ClientCert), []byte(auth.ClientKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid client certificate")
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
@@ -0,0 +1,102 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClientCertificate returns a self signed client certificate and its key in PEM format
+func newTestClientCertificate(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestClientTLSConfigPresentsCertificate(t *testing.T) {
+	clientCert, clientKey := newTestClientCertificate(t, "store-105")
+	block, _ := pem.Decode([]byte(clientCert))
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(parsed)
+
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if len(request.TLS.PeerCertificates) == 0 || request.TLS.PeerCertificates[0].Subject.CommonName != "store-105" {
+			writer.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	testServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	// Trust the test server
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(testServer.Certificate())
+	SetTLSPolicy(&TLSPolicy{rootCAs: rootCAs})
+	defer SetTLSPolicy(nil)
+
+	client, err := getHTTPClient(webhookConnectionTimeout, "", Auth{AuthType: "bearer", Token: "t", ClientCert: clientCert, ClientKey: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("Expected the handshake to succeed, received %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected the client certificate to be presented, received %d", response.StatusCode)
+	}
+}
+
+func TestClientTLSConfigValidation(t *testing.T) {
+	clientCert, _ := newTestClientCertificate(t, "store-105")
+	_, otherKey := newTestClientCertificate(t, "store-106")
+
+	if tlsConfig, err := clientTLSConfig(Auth{AuthType: "bearer", Token: "t"}); tlsConfig != nil || err != nil {
+		t.Errorf("Expected no TLS settings without a certificate, received %v %v", tlsConfig, err)
+	}
+	if _, err := clientTLSConfig(Auth{ClientCert: clientCert}); err == nil {
+		t.Error("Expected a certificate without its key to be rejected")
+	}
+	if _, err := clientTLSConfig(Auth{ClientCert: clientCert, ClientKey: otherKey}); err == nil {
+		t.Error("Expected a key that does not match the certificate to be rejected")
+	}
+}
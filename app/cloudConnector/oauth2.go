@@ -0,0 +1,197 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+	"github.com/pkg/errors"
+)
+
+// Client authentication methods of the token endpoint (RFC 6749 section 2.3.1)
+const (
+	ClientSecretBasic = "client_secret_basic"
+	ClientSecretPost  = "client_secret_post"
+)
+
+const (
+	clientCredentialsGrant = "client_credentials"
+	refreshTokenGrant      = "refresh_token"
+	formApplication        = "application/x-www-form-urlencoded"
+	tokenResponseMaxSize   = 1 << 20
+)
+
+// accessToken is a token issued by an OAuth2 authorization server
+type accessToken struct {
+	TokenType      string
+	AccessToken    string
+	ExpirationDate int64
+	// RefreshDate is when the token is replaced, shortly before it expires
+	RefreshDate int64
+}
+
+func (token *accessToken) valid() bool {
+	return token != nil && token.AccessToken != "" && token.ExpirationDate > helper.UnixMilliNow()
+}
+
+func (token *accessToken) authorization() string {
+	return token.TokenType + " " + token.AccessToken
+}
+
+// tokenResponse is the successful response of the token endpoint (RFC 6749 section 5.1)
+type tokenResponse struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresIn    expiresIn `json:"expires_in"`
+	RefreshToken string    `json:"refresh_token"`
+	Scope        string    `json:"scope"`
+}
+
+// tokenErrorResponse is the error response of the token endpoint (RFC 6749 section 5.2)
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// expiresIn is the token lifetime in seconds. Some providers send it as a string, ex. Azure AD v1.
+type expiresIn int64
+
+func (seconds *expiresIn) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*seconds = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return errors.Errorf("invalid expires_in %s", string(data))
+	}
+	*seconds = expiresIn(value)
+	return nil
+}
+
+func (response *tokenResponse) accessToken() *accessToken {
+	tokenType := response.TokenType
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+	lifetime := time.Duration(response.ExpiresIn) * time.Second
+	margin := lifetime / 10
+	if margin > maxTokenRefreshMargin {
+		margin = maxTokenRefreshMargin
+	}
+
+	now := helper.UnixMilliNow()
+	return &accessToken{
+		TokenType:      tokenType,
+		AccessToken:    response.AccessToken,
+		ExpirationDate: now + int64(lifetime/time.Millisecond),
+		RefreshDate:    now + int64((lifetime-margin)/time.Millisecond),
+	}
+}
+
+// newTokenRequest creates the token request of the auth settings.
+// The jwt-bearer and service-account auth types exchange a signed assertion. Otherwise a refresh token is exchanged with the refresh token grant, otherwise a client ID selects the client credentials
+// grant. Without either the legacy request is made, an empty POST with Data as the Authorization header.
+func newTokenRequest(auth Auth) (*http.Request, error) {
+	switch strings.ToLower(auth.AuthType) {
+	case jwtBearerAuth, serviceAccountAuth:
+		return newJWTBearerTokenRequest(auth, time.Now())
+	}
+
+	if auth.RefreshToken != "" {
+		form := url.Values{
+			"grant_type":    {refreshTokenGrant},
+			"refresh_token": {auth.RefreshToken},
+		}
+		if auth.Scope != "" {
+			form.Set("scope", auth.Scope)
+		}
+		return newTokenFormRequest(auth, form)
+	}
+
+	if auth.ClientID == "" {
+		request, err := http.NewRequest(http.MethodPost, auth.Endpoint, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create token request")
+		}
+		request.Header.Set("Authorization", auth.Data)
+		return request, nil
+	}
+
+	form := url.Values{"grant_type": {clientCredentialsGrant}}
+	if auth.Scope != "" {
+		form.Set("scope", auth.Scope)
+	}
+	if auth.Audience != "" {
+		form.Set("audience", auth.Audience)
+	}
+	return newTokenFormRequest(auth, form)
+}
+
+// newTokenFormRequest posts the form to the token endpoint along with the client credentials.
+// Public clients have no secret and only identify themselves with their client ID.
+func newTokenFormRequest(auth Auth, form url.Values) (*http.Request, error) {
+	confidential := auth.ClientID != "" && auth.ClientSecret != ""
+	switch auth.ClientAuthMethod {
+	case ClientSecretPost:
+		if confidential {
+			form.Set("client_id", auth.ClientID)
+			form.Set("client_secret", auth.ClientSecret)
+		}
+	case "", ClientSecretBasic:
+	default:
+		return nil, errors.Errorf("unsupported client authentication method %s", auth.ClientAuthMethod)
+	}
+	if !confidential && auth.ClientID != "" {
+		form.Set("client_id", auth.ClientID)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, auth.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create token request")
+	}
+	request.Header.Set("Content-Type", formApplication)
+	request.Header.Set("Accept", "application/json")
+	if confidential && auth.ClientAuthMethod != ClientSecretPost {
+		// The credentials are form encoded before being used as basic auth (RFC 6749 section 2.3.1)
+		request.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+	}
+	return request, nil
+}
+
+// parseTokenResponse decodes the token endpoint response, turning error responses into errors
+func parseTokenResponse(response *http.Response) (*tokenResponse, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, response.Body, tokenResponseMaxSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read token response")
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var tokenError tokenErrorResponse
+		if json.Unmarshal(body, &tokenError) == nil && tokenError.Error != "" {
+			return nil, errors.Errorf("StatusCode %d with error %s: %s", response.StatusCode, tokenError.Error, tokenError.ErrorDescription)
+		}
+		return nil, errors.Errorf("StatusCode %d with following response %s", response.StatusCode, string(body))
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode token response")
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+	return &token, nil
+}
@@ -0,0 +1,191 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestClientCredentialsServer(t *testing.T, authMethod string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.EscapedPath() {
+		case "/oauth":
+			if contentType := request.Header.Get("Content-Type"); contentType != formApplication {
+				t.Errorf("Expected token request content type %s, received %s", formApplication, contentType)
+			}
+			if err := request.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if request.PostForm.Get("grant_type") != "client_credentials" ||
+				request.PostForm.Get("scope") != "inventory.write" ||
+				request.PostForm.Get("audience") != "https://api.example.com" {
+				t.Errorf("Unexpected token request form %v", request.PostForm)
+			}
+
+			clientID, clientSecret, basic := request.BasicAuth()
+			if authMethod == ClientSecretPost {
+				basic = false
+				clientID = request.PostForm.Get("client_id")
+				clientSecret = request.PostForm.Get("client_secret")
+			} else if !basic {
+				t.Error("Expected client credentials as basic auth")
+			}
+			// Basic credentials are form encoded first
+			if basic && clientSecret != "s%3Acret" || !basic && clientSecret != "s:cret" || clientID != "connector" {
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusUnauthorized)
+				_, _ = writer.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+				return
+			}
+
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write([]byte(`{"access_token":"typed-token","token_type":"Bearer","expires_in":"3599"}`))
+		case "/callwebhook":
+			if authorization := request.Header.Get("Authorization"); authorization != "Bearer typed-token" {
+				t.Errorf("Expected bearer token, received %s", authorization)
+			}
+		default:
+			t.Errorf("Unexpected request to %s", request.URL.EscapedPath())
+		}
+	}))
+}
+
+func newClientCredentialsWebhook(serverURL string, authMethod string) Webhook {
+	webHook := GenerateWebhook(serverURL, true, http.MethodPost)
+	webHook.Auth.Data = ""
+	webHook.Auth.ClientID = "connector"
+	webHook.Auth.ClientSecret = "s:cret"
+	webHook.Auth.Scope = "inventory.write"
+	webHook.Auth.Audience = "https://api.example.com"
+	webHook.Auth.ClientAuthMethod = authMethod
+	return webHook
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	for _, authMethod := range []string{"", ClientSecretBasic, ClientSecretPost} {
+		accessTokens = newTokenCache(tokenCacheCapacity)
+		testMockServer := newTestClientCredentialsServer(t, authMethod)
+
+		if _, err := ProcessWebhook(newClientCredentialsWebhook(testMockServer.URL, authMethod), ""); err != nil {
+			t.Errorf("Client authentication %q failed: %s", authMethod, err.Error())
+		}
+		if accessTokens.len() != 1 {
+			t.Errorf("Expected the token to be cached for client authentication %q", authMethod)
+		}
+		testMockServer.Close()
+	}
+}
+
+func TestClientCredentialsGrantError(t *testing.T) {
+	accessTokens = newTokenCache(tokenCacheCapacity)
+	testMockServer := newTestClientCredentialsServer(t, ClientSecretPost)
+	defer testMockServer.Close()
+
+	webHook := newClientCredentialsWebhook(testMockServer.URL, ClientSecretPost)
+	webHook.Auth.ClientSecret = "wrong"
+
+	_, err := ProcessWebhook(webHook, "")
+	if err == nil {
+		t.Fatal("Expected the token request to fail")
+	}
+	if !strings.Contains(err.Error(), "invalid_client") || !strings.Contains(err.Error(), "bad secret") {
+		t.Errorf("Expected the OAuth2 error to be reported, received %s", err.Error())
+	}
+}
+
+func TestRefreshTokenGrantPersistsRotatedToken(t *testing.T) {
+	accessTokens = newTokenCache(tokenCacheCapacity)
+	dir, err := ioutil.TempDir("", "refreshtokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewRefreshTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRefreshTokenStore(store)
+	defer SetRefreshTokenStore(nil)
+
+	var mutex sync.Mutex
+	validRefreshToken := "refresh-1"
+	rotations := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.EscapedPath() != "/oauth" {
+			return
+		}
+		if err := request.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if request.PostForm.Get("grant_type") != "refresh_token" || request.PostForm.Get("client_id") != "public-client" {
+			t.Errorf("Unexpected token request form %v", request.PostForm)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if request.PostForm.Get("refresh_token") != validRefreshToken {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		// Every exchange rotates the refresh token, and the access token has no lifetime so it is not cached
+		rotations++
+		validRefreshToken = fmt.Sprintf("refresh-%d", rotations+1)
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"token_type":    "Bearer",
+			"refresh_token": validRefreshToken,
+		})
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, true, http.MethodPost)
+	webHook.Auth.Data = ""
+	webHook.Auth.ClientID = "public-client"
+	webHook.Auth.RefreshToken = "refresh-1"
+
+	for i := 0; i < 3; i++ {
+		if _, err := ProcessWebhook(webHook, ""); err != nil {
+			t.Fatalf("Call %d failed: %s", i+1, err.Error())
+		}
+	}
+
+	// A restart keeps using the rotated refresh token
+	reopened, err := NewRefreshTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current := reopened.current(webHook.Auth); current != "refresh-4" {
+		t.Errorf("Expected the last rotated refresh token to be persisted, found %s", current)
+	}
+
+	// Exchanges for other scopes are not cached together, but still must not send the same refresh token twice
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(scope string) {
+			defer wait.Done()
+			scoped := webHook
+			scoped.Auth.Scope = scope
+			if _, err := ProcessWebhook(scoped, ""); err != nil {
+				t.Errorf("Concurrent call for %s failed: %s", scope, err.Error())
+			}
+		}(fmt.Sprintf("scope-%d", i))
+	}
+	wait.Wait()
+	if current := store.current(webHook.Auth); current != "refresh-12" {
+		t.Errorf("Expected every concurrent exchange to rotate the refresh token, found %s", current)
+	}
+}
@@ -0,0 +1,439 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	deliveryFileExtension = ".json"
+	tempFileExtension     = ".tmp"
+	// Only the most recent attempts are kept so a long outage does not grow the delivery without bounds
+	maxDeliveryHistory = 50
+)
+
+var errCorruptDelivery = errors.New("corrupted delivery")
+
+// Delivery is an async webhook call waiting in the delivery queue
+type Delivery struct {
+	ID         string            `json:"id"`
+	Sequence   uint64            `json:"sequence"`
+	Webhook    Webhook           `json:"webhook"`
+	EnqueuedAt int64             `json:"enqueuedat"`
+	Attempts   int               `json:"attempts"`
+	History    []DeliveryAttempt `json:"history"`
+}
+
+// DeliveryQueueSettings controls how the delivery queue drains
+type DeliveryQueueSettings struct {
+	Proxy string
+	// RetryInterval is how long the queue waits before calling an unreachable destination again
+	RetryInterval time.Duration
+	// MaxAttempts moves a delivery to the dead letters after that many calls, 0 retries until it succeeds
+	MaxAttempts int
+	// DeadLetters receives the deliveries that failed for good, they are dropped when it is nil
+	DeadLetters *DeadLetterStore
+	// Jobs is kept up to date with the status of every delivery when set
+	Jobs *JobTracker
+}
+
+// DeliveryQueue is a durable FIFO queue of async webhook deliveries.
+// Every delivery is written to its own file before Enqueue returns, so pending deliveries
+// survive a restart and are replayed in the order they were accepted.
+type DeliveryQueue struct {
+	dir      string
+	settings DeliveryQueueSettings
+
+	// mutex guards the sequence and the queue files against concurrent rewrites
+	mutex    sync.Mutex
+	sequence uint64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	// callbacks tracks the outcome notifications still being posted
+	callbacks sync.WaitGroup
+}
+
+// NewDeliveryQueue opens (or creates) the delivery queue stored in dir
+func NewDeliveryQueue(dir string, settings DeliveryQueueSettings) (*DeliveryQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "unable to create delivery queue directory %s", dir)
+	}
+
+	queue := &DeliveryQueue{
+		dir:      dir,
+		settings: settings,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	sequences, err := queue.pending()
+	if err != nil {
+		return nil, err
+	}
+	if len(sequences) > 0 {
+		queue.sequence = sequences[len(sequences)-1]
+	}
+
+	// Deliveries left from a previous run are still queued
+	if settings.Jobs != nil {
+		for _, sequence := range sequences {
+			if delivery, err := queue.read(sequence); err == nil {
+				settings.Jobs.queued(delivery.ID, delivery.Attempts)
+			}
+		}
+	}
+
+	return queue, nil
+}
+
+// Enqueue persists the webhook to disk and schedules it for delivery
+func (queue *DeliveryQueue) Enqueue(id string, webhook Webhook) error {
+	queue.mutex.Lock()
+	queue.sequence++
+	delivery := Delivery{
+		ID:         id,
+		Sequence:   queue.sequence,
+		Webhook:    webhook,
+		EnqueuedAt: helper.UnixMilliNow(),
+	}
+	err := queue.write(delivery)
+	queue.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	queue.settings.Jobs.queued(id, 0)
+
+	metrics.GetOrRegisterGauge("CloudConnector.DeliveryQueue.Enqueued", nil).Update(1)
+
+	// Wake up the worker without blocking if it is already busy
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of deliveries waiting in the queue
+func (queue *DeliveryQueue) Len() int {
+	sequences, err := queue.pending()
+	if err != nil {
+		return 0
+	}
+	return len(sequences)
+}
+
+// Start begins draining the queue in the background
+func (queue *DeliveryQueue) Start() {
+	go queue.run()
+}
+
+// Stop waits for the in-flight delivery and its callback to finish and stops the background worker.
+// Deliveries still in the queue are left on disk and resumed on the next start.
+func (queue *DeliveryQueue) Stop() {
+	close(queue.stop)
+	<-queue.done
+	queue.callbacks.Wait()
+}
+
+func (queue *DeliveryQueue) run() {
+	defer close(queue.done)
+
+	for {
+		delivery, err := queue.head()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Method": "DeliveryQueue.run",
+				"Action": "read the next delivery",
+				"Error":  err.Error(),
+			}).Error("Unable to read delivery queue")
+			if !queue.wait(queue.settings.RetryInterval) {
+				return
+			}
+			continue
+		}
+
+		if delivery == nil {
+			select {
+			case <-queue.wake:
+				continue
+			case <-queue.stop:
+				return
+			}
+		}
+
+		if queue.deliver(delivery) {
+			continue
+		}
+
+		// The destination is unreachable, hold the queue so the order is kept and try again later
+		if !queue.wait(queue.settings.RetryInterval) {
+			return
+		}
+	}
+}
+
+// deliver makes a single delivery attempt and reports whether the queue can move on to the next delivery
+func (queue *DeliveryQueue) deliver(delivery *Delivery) bool {
+	mSuccess := metrics.GetOrRegisterGauge("CloudConnector.DeliveryQueue.Success", nil)
+	mError := metrics.GetOrRegisterGauge("CloudConnector.DeliveryQueue.Error", nil)
+	mRetry := metrics.GetOrRegisterGauge("CloudConnector.DeliveryQueue.Retry", nil)
+
+	queue.settings.Jobs.inFlight(delivery.ID)
+	response, attempts, err := DeliverWebhook(delivery.Webhook, queue.settings.Proxy)
+	delivery.Attempts += len(attempts)
+	delivery.History = append(delivery.History, attempts...)
+	if len(delivery.History) > maxDeliveryHistory {
+		delivery.History = delivery.History[len(delivery.History)-maxDeliveryHistory:]
+	}
+
+	if err == nil {
+		log.WithFields(log.Fields{
+			"Method":      "DeliveryQueue.deliver",
+			"TraceID":     delivery.ID,
+			"HTTP Method": delivery.Webhook.Method,
+			"webhookURL":  delivery.Webhook.URL,
+			"Attempts":    delivery.Attempts,
+		}).Debug("Successful!")
+		mSuccess.Update(1)
+		queue.settings.Jobs.finish(delivery.ID, JobSucceeded, delivery.Attempts, response, nil)
+		queue.remove(delivery)
+		queue.notify(delivery, JobSucceeded, response, nil)
+		return true
+	}
+
+	logFields := log.Fields{
+		"Method":      "DeliveryQueue.deliver",
+		"Action":      "process the webhook request",
+		"HTTP Method": delivery.Webhook.Method,
+		"Webhook URL": delivery.Webhook.URL,
+		"TraceID":     delivery.ID,
+		"Attempts":    delivery.Attempts,
+	}
+
+	maxAttempts := queue.settings.MaxAttempts
+	if isDeliveryRetryable(response) && (maxAttempts <= 0 || delivery.Attempts < maxAttempts) {
+		log.WithFields(logFields).Warn(err.Error())
+		mRetry.Update(1)
+		if writeErr := queue.update(*delivery); writeErr != nil {
+			log.WithFields(logFields).Error(writeErr.Error())
+		}
+		queue.settings.Jobs.queued(delivery.ID, delivery.Attempts)
+		return false
+	}
+
+	log.WithFields(logFields).Error(err.Error())
+	mError.Update(1)
+	queue.settings.Jobs.finish(delivery.ID, JobFailed, delivery.Attempts, response, err)
+	queue.deadLetter(delivery, response)
+	queue.remove(delivery)
+	queue.notify(delivery, JobFailed, response, err)
+	return true
+}
+
+// notify posts the outcome to the webhook callback without holding up the next delivery
+func (queue *DeliveryQueue) notify(delivery *Delivery, status string, response *WebhookResponse, err error) {
+	if delivery.Webhook.Callback == nil {
+		return
+	}
+
+	queue.callbacks.Add(1)
+	go func() {
+		defer queue.callbacks.Done()
+		notifyCallback(delivery, status, response, err)
+	}()
+}
+
+// deadLetter hands a delivery that failed for good over to the dead-letter store
+func (queue *DeliveryQueue) deadLetter(delivery *Delivery, response *WebhookResponse) {
+	if queue.settings.DeadLetters == nil {
+		return
+	}
+
+	deadLetter := DeadLetter{
+		ID:         delivery.ID,
+		Webhook:    delivery.Webhook,
+		Attempts:   delivery.History,
+		EnqueuedAt: delivery.EnqueuedAt,
+		FailedAt:   helper.UnixMilliNow(),
+	}
+	if response != nil {
+		deadLetter.LastStatusCode = response.StatusCode
+		deadLetter.LastResponseBody = string(response.Body)
+	}
+
+	if err := queue.settings.DeadLetters.Add(deadLetter); err != nil {
+		log.WithFields(log.Fields{
+			"Method":  "DeliveryQueue.deadLetter",
+			"TraceID": delivery.ID,
+			"Error":   err.Error(),
+		}).Error("Unable to store failed delivery, it is lost")
+	}
+}
+
+// isDeliveryRetryable reports whether a failed delivery should stay in the queue.
+// A missing response means the destination could not be reached at all.
+func isDeliveryRetryable(response *WebhookResponse) bool {
+	if response == nil {
+		return true
+	}
+	return response.StatusCode >= http.StatusInternalServerError ||
+		response.StatusCode == http.StatusRequestTimeout ||
+		response.StatusCode == http.StatusTooManyRequests
+}
+
+// wait sleeps for the given duration and returns false if the queue was stopped meanwhile
+func (queue *DeliveryQueue) wait(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-queue.stop:
+		return false
+	}
+}
+
+func (queue *DeliveryQueue) head() (*Delivery, error) {
+	sequences, err := queue.pending()
+	if err != nil || len(sequences) == 0 {
+		return nil, err
+	}
+
+	delivery, err := queue.read(sequences[0])
+	if err == errCorruptDelivery {
+		// A corrupted entry would block the queue forever, so it is set aside
+		corruptPath := queue.path(sequences[0]) + ".corrupt"
+		queue.mutex.Lock()
+		renameErr := os.Rename(queue.path(sequences[0]), corruptPath)
+		queue.mutex.Unlock()
+		if renameErr != nil {
+			return nil, errors.Wrapf(renameErr, "unable to set aside corrupted delivery %d", sequences[0])
+		}
+		return nil, errors.Wrapf(err, "delivery moved to %s", corruptPath)
+	}
+	return delivery, err
+}
+
+func (queue *DeliveryQueue) read(sequence uint64) (*Delivery, error) {
+	// A delivery that cannot be decrypted is not corrupted, it waits for the missing key
+	data, err := readSealedFile(queue.path(sequence))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read delivery %d", sequence)
+	}
+
+	var delivery Delivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, errCorruptDelivery
+	}
+	return &delivery, nil
+}
+
+// pending returns the sequence numbers of all queued deliveries in order
+func (queue *DeliveryQueue) pending() ([]uint64, error) {
+	files, err := ioutil.ReadDir(queue.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read delivery queue directory %s", queue.dir)
+	}
+
+	var sequences []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !isDeliveryFile(name) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, deliveryFileExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return sequences, nil
+}
+
+// write stores the delivery atomically so a crash never leaves a partially written entry behind.
+// The caller holds the queue mutex.
+func (queue *DeliveryQueue) write(delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return errors.Wrapf(err, "unable to marshal delivery")
+	}
+	return writeSealedFile(queue.path(delivery.Sequence), data)
+}
+
+// update rewrites a delivery already in the queue
+func (queue *DeliveryQueue) update(delivery Delivery) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.write(delivery)
+}
+
+func (queue *DeliveryQueue) remove(delivery *Delivery) {
+	queue.mutex.Lock()
+	err := os.Remove(queue.path(delivery.Sequence))
+	queue.mutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"Method":  "DeliveryQueue.remove",
+			"TraceID": delivery.ID,
+			"Error":   err.Error(),
+		}).Error("Unable to remove delivery from queue")
+	}
+}
+
+// Reencrypt encrypts the queued deliveries with the primary key
+func (queue *DeliveryQueue) Reencrypt() (int, error) {
+	return reencryptDir(queue.dir, isDeliveryFile, &queue.mutex)
+}
+
+func (queue *DeliveryQueue) path(sequence uint64) string {
+	// Zero padded names keep the directory listing in delivery order
+	return filepath.Join(queue.dir, fmt.Sprintf("%020d%s", sequence, deliveryFileExtension))
+}
+
+func isDeliveryFile(name string) bool {
+	return strings.HasSuffix(name, deliveryFileExtension)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tempPath := path + tempFileExtension
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", tempPath)
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "unable to write %s", tempPath)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "unable to sync %s", tempPath)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "unable to close %s", tempPath)
+	}
+	return os.Rename(tempPath, path)
+}
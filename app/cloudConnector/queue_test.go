@@ -0,0 +1,206 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDeliveryQueue(t *testing.T) (*DeliveryQueue, string) {
+	dir, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatalf("Unable to create queue directory: %s", err.Error())
+	}
+	deadLetters, err := NewDeadLetterStore(filepath.Join(dir, "deadletters"))
+	if err != nil {
+		t.Fatalf("Unable to create dead-letter store: %s", err.Error())
+	}
+	queue, err := NewDeliveryQueue(filepath.Join(dir, "queue"), newTestQueueSettings(deadLetters))
+	if err != nil {
+		t.Fatalf("Unable to create delivery queue: %s", err.Error())
+	}
+	return queue, dir
+}
+
+func newTestQueueSettings(deadLetters *DeadLetterStore) DeliveryQueueSettings {
+	return DeliveryQueueSettings{
+		RetryInterval: 10 * time.Millisecond,
+		DeadLetters:   deadLetters,
+	}
+}
+
+func waitForEmptyQueue(t *testing.T, queue *DeliveryQueue) {
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Queue was not drained, %d deliveries left", queue.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryQueueSurvivesRestartInOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var payload string
+		_ = json.NewDecoder(request.Body).Decode(&payload)
+		mutex.Lock()
+		received = append(received, payload)
+		mutex.Unlock()
+	}))
+	defer testMockServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+
+	for _, payload := range []string{"first", "second", "third"} {
+		webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+		webHook.Payload = payload
+		if err := queue.Enqueue(payload, webHook); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a restart before anything was delivered
+	reopened, err := NewDeliveryQueue(filepath.Join(dir, "queue"), newTestQueueSettings(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 3 {
+		t.Fatalf("Expected 3 pending deliveries after restart, found %d", reopened.Len())
+	}
+
+	reopened.Start()
+	waitForEmptyQueue(t, reopened)
+	reopened.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"first", "second", "third"}
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %d deliveries, received %d", len(expected), len(received))
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("Delivery %d out of order: expected %s, received %s", i, expected[i], received[i])
+		}
+	}
+}
+
+func TestDeliveryQueueRetriesUnavailableDestination(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		if calls < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer testMockServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+	queue.settings.Jobs = NewJobTracker(10)
+	queue.Start()
+	defer queue.Stop()
+
+	if err := queue.Enqueue("trace", GenerateWebhook(testMockServer.URL, false, http.MethodPost)); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyQueue(t, queue)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls != 3 {
+		t.Errorf("Expected delivery to be attempted 3 times, attempted %d", calls)
+	}
+
+	job, ok := queue.settings.Jobs.Get("trace")
+	if !ok || job.Status != JobSucceeded || job.Attempts != 3 || job.StatusCode != http.StatusOK {
+		t.Errorf("Expected job to succeed after 3 attempts with status 200, received %v", job)
+	}
+}
+
+func TestDeliveryQueueDeadLettersRejectedDelivery(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("missing sku"))
+	}))
+	defer testMockServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+	queue.settings.Jobs = NewJobTracker(10)
+	queue.Start()
+	defer queue.Stop()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Payload = "rejected"
+	if err := queue.Enqueue("trace-rejected", webHook); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyQueue(t, queue)
+
+	deadLetter, err := queue.settings.DeadLetters.Get("trace-rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadLetter.LastStatusCode != http.StatusBadRequest {
+		t.Errorf("Expected last status code 400, received %d", deadLetter.LastStatusCode)
+	}
+	if deadLetter.LastResponseBody != "missing sku" {
+		t.Errorf("Expected last response body to be kept, received %q", deadLetter.LastResponseBody)
+	}
+	if len(deadLetter.Attempts) != 1 || deadLetter.Attempts[0].StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a single recorded attempt, received %v", deadLetter.Attempts)
+	}
+	if deadLetter.Webhook.Payload != "rejected" {
+		t.Errorf("Expected the original webhook to be kept, received payload %v", deadLetter.Webhook.Payload)
+	}
+
+	job, ok := queue.settings.Jobs.Get("trace-rejected")
+	if !ok || job.Status != JobFailed || job.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected job to fail with status 400, received %v", job)
+	}
+}
+
+func TestDeliveryQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testMockServer.Close()
+
+	queue, dir := newTestDeliveryQueue(t)
+	defer os.RemoveAll(dir)
+	queue.settings.MaxAttempts = 2
+	queue.Start()
+	defer queue.Stop()
+
+	if err := queue.Enqueue("trace-unavailable", GenerateWebhook(testMockServer.URL, false, http.MethodPost)); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyQueue(t, queue)
+
+	deadLetter, err := queue.settings.DeadLetters.Get("trace-unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetter.Attempts) != 2 {
+		t.Errorf("Expected 2 recorded attempts, received %d", len(deadLetter.Attempts))
+	}
+}
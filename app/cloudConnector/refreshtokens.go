@@ -0,0 +1,156 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshTokens keeps the refresh tokens rotated by the authorization servers, see SetRefreshTokenStore
+var refreshTokens *RefreshTokenStore
+
+// SetRefreshTokenStore sets where rotated refresh tokens are persisted.
+// Without a store rotated refresh tokens are lost and the configured one is sent again.
+func SetRefreshTokenStore(store *RefreshTokenStore) {
+	refreshTokens = store
+}
+
+// RefreshTokenStore persists the latest refresh token issued in place of a configured one.
+// Entries are keyed by the endpoint, the client and the configured refresh token, so the caller
+// keeps sending the configured token while the connector uses the rotated one.
+type RefreshTokenStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// refreshTokenFileExtension is the extension of the files holding the rotated refresh tokens
+const refreshTokenFileExtension = ".json"
+
+// refreshTokenExchanges holds the lock of every refresh token being exchanged, see lockRefreshToken
+var refreshTokenExchanges = struct {
+	sync.Mutex
+	entries map[string]*refreshTokenExchange
+}{entries: map[string]*refreshTokenExchange{}}
+
+type refreshTokenExchange struct {
+	sync.Mutex
+	waiting int
+}
+
+// lockRefreshToken serializes the exchanges of the refresh token of the auth settings and returns the unlock function.
+// Access tokens are cached per scope and audience, but they all rotate the same refresh token,
+// so each exchange must read the token rotated by the previous one instead of sending the same token again.
+func lockRefreshToken(auth Auth) func() {
+	key := refreshTokenKey(auth)
+
+	refreshTokenExchanges.Lock()
+	exchange, ok := refreshTokenExchanges.entries[key]
+	if !ok {
+		exchange = &refreshTokenExchange{}
+		refreshTokenExchanges.entries[key] = exchange
+	}
+	exchange.waiting++
+	refreshTokenExchanges.Unlock()
+
+	exchange.Lock()
+	return func() {
+		exchange.Unlock()
+
+		refreshTokenExchanges.Lock()
+		defer refreshTokenExchanges.Unlock()
+		// The lock is dropped once nobody waits on it
+		if exchange.waiting--; exchange.waiting == 0 {
+			delete(refreshTokenExchanges.entries, key)
+		}
+	}
+}
+
+// refreshTokenKey identifies the configured refresh token of the endpoint and client, without holding the token
+func refreshTokenKey(auth Auth) string {
+	return hashSecret(auth.Endpoint + "\n" + auth.ClientID + "\n" + auth.RefreshToken)
+}
+
+type storedRefreshToken struct {
+	RefreshToken string `json:"refreshtoken"`
+	UpdatedAt    int64  `json:"updatedat"`
+}
+
+// NewRefreshTokenStore opens (or creates) the refresh token store in dir
+func NewRefreshTokenStore(dir string) (*RefreshTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "unable to create refresh token directory %s", dir)
+	}
+	return &RefreshTokenStore{dir: dir}, nil
+}
+
+// current returns the refresh token to exchange for the auth settings
+func (store *RefreshTokenStore) current(auth Auth) string {
+	if store == nil {
+		return auth.RefreshToken
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	data, err := readSealedFile(store.path(auth))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{
+				"Method":   "RefreshTokenStore.current",
+				"Endpoint": auth.Endpoint,
+				"Error":    err.Error(),
+			}).Error("Unable to read rotated refresh token, using the configured one")
+		}
+		return auth.RefreshToken
+	}
+
+	var stored storedRefreshToken
+	if err := json.Unmarshal(data, &stored); err != nil || stored.RefreshToken == "" {
+		return auth.RefreshToken
+	}
+	return stored.RefreshToken
+}
+
+// rotate persists the refresh token issued in place of the configured one
+func (store *RefreshTokenStore) rotate(auth Auth, refreshToken string) error {
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(storedRefreshToken{RefreshToken: refreshToken, UpdatedAt: helper.UnixMilliNow()})
+	if err != nil {
+		return errors.Wrapf(err, "unable to marshal refresh token")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return writeSealedFile(store.path(auth), data)
+}
+
+// Reencrypt encrypts the refresh tokens with the primary key
+func (store *RefreshTokenStore) Reencrypt() (int, error) {
+	if store == nil {
+		return 0, nil
+	}
+	return reencryptDir(store.dir, isRefreshTokenFile, &store.mutex)
+}
+
+func (store *RefreshTokenStore) path(auth Auth) string {
+	return filepath.Join(store.dir, refreshTokenKey(auth)+refreshTokenFileExtension)
+}
+
+func isRefreshTokenFile(name string) bool {
+	return strings.HasSuffix(name, refreshTokenFileExtension)
+}
@@ -0,0 +1,190 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Network error classes that can be listed in RetryPolicy.RetryableErrors
+const (
+	TimeoutError           = "timeout"
+	ConnectionRefusedError = "connectionrefused"
+	ConnectionResetError   = "connectionreset"
+	DNSError               = "dns"
+)
+
+// maxRetryBackoff bounds the computed backoff of a policy without MaxBackoff
+const maxRetryBackoff = 24 * time.Hour
+
+// WithDefaults fills the unset fields of the policy from defaults
+func (policy *RetryPolicy) WithDefaults(defaults RetryPolicy) *RetryPolicy {
+	if policy == nil {
+		return &defaults
+	}
+	merged := *policy
+	if merged.MaxAttempts == 0 {
+		merged.MaxAttempts = defaults.MaxAttempts
+	}
+	if merged.InitialBackoff == 0 {
+		merged.InitialBackoff = defaults.InitialBackoff
+	}
+	if merged.MaxBackoff == 0 {
+		merged.MaxBackoff = defaults.MaxBackoff
+	}
+	if merged.Jitter == 0 {
+		merged.Jitter = defaults.Jitter
+	}
+	if merged.RetryableStatusCodes == nil {
+		merged.RetryableStatusCodes = defaults.RetryableStatusCodes
+	}
+	if merged.RetryableErrors == nil {
+		merged.RetryableErrors = defaults.RetryableErrors
+	}
+	return &merged
+}
+
+// isRetryable reports whether the outcome of an attempt is worth another try
+func (policy *RetryPolicy) isRetryable(response *WebhookResponse, err error) bool {
+	if response != nil {
+		for _, statusCode := range policy.RetryableStatusCodes {
+			if response.StatusCode == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	errorClass := networkErrorClass(err)
+	if errorClass == "" {
+		return false
+	}
+	for _, retryable := range policy.RetryableErrors {
+		if strings.EqualFold(retryable, errorClass) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait before the next attempt. A Retry-After header sent by the destination
+// takes precedence over the computed backoff; false is returned when the destination asks to wait longer
+// than the policy allows.
+func (policy *RetryPolicy) backoff(attempt int, response *WebhookResponse) (time.Duration, bool) {
+	maxBackoff := time.Duration(policy.MaxBackoff) * time.Millisecond
+	limit := maxBackoff
+	if limit <= 0 {
+		limit = maxRetryBackoff
+	}
+
+	// Capped before the conversion, as the exponential backoff of late attempts overflows a Duration
+	backoff := float64(policy.InitialBackoff) * math.Pow(2, float64(attempt-1)) * float64(time.Millisecond)
+	if policy.Jitter > 0 {
+		backoff *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	delay := limit
+	if backoff < float64(limit) {
+		delay = time.Duration(backoff)
+	}
+
+	if response != nil {
+		if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+			if maxBackoff > 0 && retryAfter > maxBackoff {
+				return 0, false
+			}
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+	}
+	return delay, true
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// IsTimeoutError reports whether the call failed because the destination did not answer in time
+func IsTimeoutError(err error) bool {
+	return networkErrorClass(err) == TimeoutError
+}
+
+// IsTransportError reports whether the call failed to reach the destination or to read its response
+func IsTransportError(err error) bool {
+	_, ok := errors.Cause(err).(*url.Error)
+	return ok
+}
+
+// networkErrorClass maps a transport error to one of the retryable error classes
+func networkErrorClass(err error) string {
+	for err != nil {
+		switch cause := errors.Cause(err).(type) {
+		case *url.Error:
+			if cause.Timeout() {
+				return TimeoutError
+			}
+			err = cause.Err
+		case *net.DNSError:
+			return DNSError
+		case *net.OpError:
+			if cause.Timeout() {
+				return TimeoutError
+			}
+			err = cause.Err
+		case *os.SyscallError:
+			err = cause.Err
+		case syscall.Errno:
+			switch cause {
+			case syscall.ECONNREFUSED:
+				return ConnectionRefusedError
+			case syscall.ECONNRESET, syscall.EPIPE:
+				return ConnectionResetError
+			}
+			return ""
+		case net.Error:
+			if cause.Timeout() {
+				return TimeoutError
+			}
+			return ""
+		default:
+			if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+				return ConnectionResetError
+			}
+			return ""
+		}
+	}
+	return ""
+}
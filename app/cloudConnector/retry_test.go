@@ -0,0 +1,181 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       1,
+		MaxBackoff:           2000,
+		RetryableStatusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		RetryableErrors:      []string{ConnectionRefusedError},
+	}
+}
+
+func TestProcessWebhookRetriesTransientErrors(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		if calls == 1 {
+			writer.WriteHeader(http.StatusBadGateway)
+		} else if calls == 2 {
+			writer.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Retry = newTestRetryPolicy()
+
+	response, err := ProcessWebhook(webHook, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected final status 200, received %d", response.StatusCode)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, received %d", calls)
+	}
+}
+
+func TestProcessWebhookDoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(http.StatusBadRequest)
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Retry = newTestRetryPolicy()
+
+	response, err := ProcessWebhook(webHook, "")
+	if err == nil {
+		t.Fatal("Expected error for 400 response")
+	}
+	if response == nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the 400 response to be returned with the error")
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single attempt, received %d", calls)
+	}
+}
+
+func TestProcessWebhookHonorsRetryAfter(t *testing.T) {
+	var firstCall time.Time
+	calls := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		if calls == 1 {
+			firstCall = time.Now()
+			writer.Header().Set("Retry-After", "1")
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if time.Since(firstCall) < time.Second {
+			t.Errorf("Retry-After was not honored, retried after %v", time.Since(firstCall))
+		}
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Retry = newTestRetryPolicy()
+
+	if _, err := ProcessWebhook(webHook, ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 attempts, received %d", calls)
+	}
+}
+
+func TestProcessWebhookGivesUpOnLongRetryAfter(t *testing.T) {
+	calls := 0
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.Header().Set("Retry-After", "3600")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Retry = newTestRetryPolicy()
+
+	if _, err := ProcessWebhook(webHook, ""); err == nil {
+		t.Fatal("Expected error for 503 response")
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single attempt, received %d", calls)
+	}
+}
+
+func TestProcessWebhookRetriesConnectionRefused(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	// Closing the server leaves a port nobody listens on
+	testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Retry = newTestRetryPolicy()
+
+	response, err := processWebhookAttempt(webHook, "")
+	if err == nil {
+		t.Fatal("Expected connection error")
+	}
+	if networkErrorClass(err) != ConnectionRefusedError {
+		t.Errorf("Expected error to be classified as %s, received '%s'", ConnectionRefusedError, networkErrorClass(err))
+	}
+	if !webHook.Retry.isRetryable(response, err) {
+		t.Error("Expected connection refused to be retryable")
+	}
+}
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	defaults := *newTestRetryPolicy()
+
+	var unset *RetryPolicy
+	if merged := unset.WithDefaults(defaults); merged.MaxAttempts != defaults.MaxAttempts {
+		t.Errorf("Expected defaults when no policy is given")
+	}
+
+	policy := &RetryPolicy{MaxAttempts: 7, RetryableStatusCodes: []int{}}
+	merged := policy.WithDefaults(defaults)
+	if merged.MaxAttempts != 7 {
+		t.Errorf("Expected MaxAttempts to be kept, received %d", merged.MaxAttempts)
+	}
+	if merged.MaxBackoff != defaults.MaxBackoff {
+		t.Errorf("Expected MaxBackoff to be defaulted, received %d", merged.MaxBackoff)
+	}
+	if len(merged.RetryableStatusCodes) != 0 {
+		t.Errorf("Expected explicitly empty status codes to be kept")
+	}
+}
+
+func TestRetryPolicyBackoffLateAttempts(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 500, MaxBackoff: 10000, Jitter: 0.2}
+	for _, attempt := range []int{36, 64, 100, 1100} {
+		if delay, ok := policy.backoff(attempt, nil); !ok || delay != 10*time.Second {
+			t.Errorf("Expected attempt %d to wait MaxBackoff, received %v", attempt, delay)
+		}
+	}
+
+	policy.MaxBackoff = 0
+	if delay, _ := policy.backoff(100, nil); delay != maxRetryBackoff {
+		t.Errorf("Expected attempt 100 without MaxBackoff to wait %v, received %v", maxRetryBackoff, delay)
+	}
+}
@@ -0,0 +1,146 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultKeyTemplate is the object key used when the upload has no key template,
+	// the UUID keeps uploads made in the same millisecond from colliding
+	defaultKeyTemplate = "awsfile_{unixmillis}_{uuid}"
+	// prefixObjectName names the object when the key template is a prefix ending with /
+	prefixObjectName   = "{traceId}.json"
+	maxObjectKeyLength = 1024
+)
+
+// ObjectKey expands the key template of an S3 upload, ex. stores/{storeId}/dt={yyyy}-{MM}-{dd}/{traceId}.json.
+// The placeholders are:
+//
+//	{yyyy} {MM} {dd} {HH} {mm} {ss} {unixmillis} - the upload time in UTC
+//	{traceId} - the trace ID of the request
+//	{uuid} - a random UUID
+//	{field} or {field.nested} - a string, number or boolean field of the payload
+//
+// Payload values are restricted to letters, digits, '.', '_' and '-', anything else is replaced by '_'
+// so a value can neither add path segments nor break Hive style key=value partitions.
+func ObjectKey(template string, payload interface{}, traceID string, now time.Time) (string, error) {
+	if template == "" {
+		template = defaultKeyTemplate
+	}
+	if strings.HasSuffix(template, "/") {
+		template += prefixObjectName
+	}
+	now = now.UTC()
+
+	var key strings.Builder
+	for rest := template; rest != ""; {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			key.WriteString(rest)
+			break
+		}
+		if rest[start] == '}' {
+			return "", errors.Errorf("unexpected } in key template %s", template)
+		}
+		key.WriteString(rest[:start])
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] != '}' {
+			return "", errors.Errorf("unclosed placeholder in key template %s", template)
+		}
+		name := rest[start+1 : start+1+end]
+		rest = rest[start+2+end:]
+
+		value, err := placeholderValue(name, payload, traceID, now)
+		if err != nil {
+			return "", err
+		}
+		key.WriteString(value)
+	}
+
+	if key.Len() > maxObjectKeyLength {
+		return "", errors.Errorf("object key is longer than %d bytes", maxObjectKeyLength)
+	}
+	return key.String(), nil
+}
+
+func placeholderValue(name string, payload interface{}, traceID string, now time.Time) (string, error) {
+	switch name {
+	case "":
+		return "", errors.New("empty placeholder in key template")
+	case "yyyy":
+		return now.Format("2006"), nil
+	case "MM":
+		return now.Format("01"), nil
+	case "dd":
+		return now.Format("02"), nil
+	case "HH":
+		return now.Format("15"), nil
+	case "mm":
+		return now.Format("04"), nil
+	case "ss":
+		return now.Format("05"), nil
+	case "unixmillis":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10), nil
+	case "traceId":
+		return traceID, nil
+	case "uuid":
+		return uuid.New(), nil
+	}
+
+	value, err := payloadField(payload, name)
+	if err != nil {
+		return "", err
+	}
+	return sanitizeKeySegment(value), nil
+}
+
+// payloadField returns the string form of the payload field at the dot separated path
+func payloadField(payload interface{}, path string) (string, error) {
+	value := payload
+	for _, name := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return "", errors.Errorf("payload has no field %s for the key template", path)
+		}
+		if value, ok = fields[name]; !ok || value == nil {
+			return "", errors.Errorf("payload has no field %s for the key template", path)
+		}
+	}
+
+	var text string
+	switch field := value.(type) {
+	case string:
+		text = field
+	case float64:
+		text = strconv.FormatFloat(field, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(field)
+	default:
+		return "", errors.Errorf("payload field %s of the key template must be a string, number or boolean", path)
+	}
+	if text == "" {
+		return "", errors.Errorf("payload field %s of the key template is empty", path)
+	}
+	return text, nil
+}
+
+func sanitizeKeySegment(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, value)
+}
@@ -0,0 +1,67 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestObjectKey(t *testing.T) {
+	var payload interface{}
+	if err := json.Unmarshal([]byte(`{"storeId": "store 105/a", "event": {"type": "moved", "count": 12.5, "final": true}}`), &payload); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 3, 7, 18, 4, 5, 0, time.FixedZone("PST", -8*60*60))
+
+	keys := []struct {
+		template string
+		key      string
+	}{
+		{"stores/{storeId}/{yyyy}/{MM}/{dd}/{traceId}.json", "stores/store_105_a/2019/03/08/trace-1.json"},
+		{"events/type={event.type}/dt={yyyy}-{MM}-{dd}/hour={HH}/{mm}{ss}-{event.count}-{event.final}", "events/type=moved/dt=2019-03-08/hour=02/0405-12.5-true"},
+		{"stores/{storeId}/", "stores/store_105_a/trace-1.json"},
+	}
+	for _, item := range keys {
+		key, err := ObjectKey(item.template, payload, "trace-1", now)
+		if err != nil {
+			t.Errorf("Unable to expand %s: %v", item.template, err)
+		} else if key != item.key {
+			t.Errorf("Expected %s to expand to %s, received %s", item.template, item.key, key)
+		}
+	}
+
+	// Uploads without a template in the same millisecond get distinct keys
+	first, _ := ObjectKey("", payload, "trace-1", now)
+	second, _ := ObjectKey("", payload, "trace-2", now)
+	if !regexp.MustCompile(`^awsfile_1552010645000_[0-9a-f-]{36}$`).MatchString(first) || first == second {
+		t.Errorf("Expected unique default keys, received %s and %s", first, second)
+	}
+
+	first, _ = ObjectKey("{uuid}", payload, "trace-1", now)
+	second, _ = ObjectKey("{uuid}", payload, "trace-1", now)
+	if !regexp.MustCompile(`^[0-9a-f-]{36}$`).MatchString(first) || first == second {
+		t.Errorf("Expected a new UUID on every upload, received %s and %s", first, second)
+	}
+
+	invalid := []string{
+		"stores/{storeId",
+		"stores/storeId}",
+		"stores/{}/data",
+		"stores/{{storeId}}",
+		"stores/{missing}",
+		"stores/{event}",
+		"stores/{storeId.nested}",
+	}
+	for _, template := range invalid {
+		if key, err := ObjectKey(template, payload, "trace-1", now); err == nil {
+			t.Errorf("Expected %s to be rejected, received %s", template, key)
+		}
+	}
+}
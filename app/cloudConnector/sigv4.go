@@ -0,0 +1,33 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/pkg/errors"
+)
+
+const awsSigV4Auth = "aws-sigv4"
+
+// signAWSSigV4 signs the request with AWS Signature Version 4. It runs once all the other headers are set,
+// since they are covered by the signature, and replaces any Authorization or X-Amz-* header of the caller.
+func signAWSSigV4(request *http.Request, auth Auth, body []byte, now time.Time) error {
+	if auth.Region == "" || auth.Service == "" || auth.AccessKeyID == "" || auth.SecretAccessKey == "" {
+		return errors.New("aws-sigv4 auth requires a region, a service, an access key id and a secret access key")
+	}
+
+	signer := v4.NewSigner(credentials.NewStaticCredentials(auth.AccessKeyID, auth.SecretAccessKey, auth.SessionToken))
+	if _, err := signer.Sign(request, bytes.NewReader(body), auth.Service, auth.Region, now); err != nil {
+		return errors.Wrapf(err, "unable to sign request with aws-sigv4")
+	}
+	return nil
+}
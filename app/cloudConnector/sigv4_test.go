@@ -0,0 +1,90 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var signedHeadersPattern = regexp.MustCompile(`SignedHeaders=([^,]+)`)
+
+// resignTestRequest signs the received request again, as the AWS endpoint would, to check the signature
+func resignTestRequest(t *testing.T, received *http.Request, body []byte, auth Auth) string {
+	signTime, err := time.Parse("20060102T150405Z", received.Header.Get("X-Amz-Date"))
+	if err != nil {
+		t.Fatalf("Expected an X-Amz-Date header, received %q", received.Header.Get("X-Amz-Date"))
+	}
+
+	request, _ := http.NewRequest(received.Method, "http://"+received.Host+received.URL.RequestURI(), nil)
+	match := signedHeadersPattern.FindStringSubmatch(received.Header.Get("Authorization"))
+	if match == nil {
+		t.Fatalf("Expected a SigV4 Authorization header, received %q", received.Header.Get("Authorization"))
+	}
+	for _, name := range strings.Split(match[1], ";") {
+		if name != "host" {
+			request.Header[http.CanonicalHeaderKey(name)] = received.Header[http.CanonicalHeaderKey(name)]
+		}
+	}
+
+	if err := signAWSSigV4(request, auth, body, signTime); err != nil {
+		t.Fatal(err)
+	}
+	return request.Header.Get("Authorization")
+}
+
+func TestAWSSigV4SignedWebhook(t *testing.T) {
+	auth := Auth{
+		AuthType:        "aws-sigv4",
+		Region:          "us-west-2",
+		Service:         "execute-api",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		SessionToken:    "session-token",
+	}
+
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		authorization := request.Header.Get("Authorization")
+
+		if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(authorization, "/us-west-2/execute-api/aws4_request") {
+			t.Errorf("Unexpected SigV4 credential scope %s", authorization)
+		}
+		if request.Header.Get("X-Amz-Security-Token") != "session-token" {
+			t.Errorf("Expected the session token header, received %q", request.Header.Get("X-Amz-Security-Token"))
+		}
+		if !strings.Contains(authorization, "x-store-id") {
+			t.Errorf("Expected the caller headers to be signed, received %s", authorization)
+		}
+		if expected := resignTestRequest(t, request, body, auth); authorization != expected {
+			t.Errorf("Signature mismatch, expected %s, received %s", expected, authorization)
+		}
+	}))
+	defer testMockServer.Close()
+
+	webHook := GenerateWebhook(testMockServer.URL, false, http.MethodPost)
+	webHook.Payload = map[string]interface{}{"sku": "MS122-38"}
+	webHook.Header = http.Header{"X-Store-Id": {"store-1"}}
+	webHook.Auth = auth
+
+	if _, err := ProcessWebhook(webHook, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAWSSigV4RequiresCredentials(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "http://localhost/test", nil)
+	if err := signAWSSigV4(request, Auth{AuthType: awsSigV4Auth, Region: "us-west-2"}, nil, time.Now()); err == nil {
+		t.Error("Expected missing credentials to be rejected")
+	}
+}
@@ -0,0 +1,203 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tlsPolicy applies to every outbound connection, see SetTLSPolicy
+var tlsPolicy *TLSPolicy
+
+// SetTLSPolicy sets the TLS settings of the outbound connections.
+// Without a policy the system CAs and the Go defaults are used.
+func SetTLSPolicy(policy *TLSPolicy) {
+	tlsPolicy = policy
+	// Pooled connections were established with the previous policy
+	transports.flush()
+}
+
+// TLSPolicySettings configures the TLS policy of the outbound connections
+type TLSPolicySettings struct {
+	// CABundlePaths are PEM files trusted on top of the system CAs, ex. the CA of a corporate proxy
+	CABundlePaths []string
+	// MinVersion is the lowest TLS version accepted: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// CipherSuites restricts the TLS 1.0 to 1.2 cipher suites to the given names, ex. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	CipherSuites []string
+	// Pins are host=<base64 SHA-256 of the SubjectPublicKeyInfo> entries, a pinned host must present
+	// one of its pinned keys in the verified chain
+	Pins []string
+}
+
+// TLSPolicy holds the parsed TLS settings of the outbound connections
+type TLSPolicy struct {
+	rootCAs      *x509.CertPool
+	minVersion   uint16
+	cipherSuites []uint16
+	pins         map[string][]string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuites are the TLS 1.0 to 1.2 cipher suites implemented by crypto/tls, by their IANA name
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                      tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":              tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":                tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	// Names of the Go constants
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// NewTLSPolicy validates the settings and loads the CA bundles
+func NewTLSPolicy(settings TLSPolicySettings) (*TLSPolicy, error) {
+	policy := &TLSPolicy{pins: map[string][]string{}}
+
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			return nil, errors.Errorf("invalid TLS minimum version %s", settings.MinVersion)
+		}
+		policy.minVersion = version
+	}
+
+	if len(settings.CABundlePaths) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		for _, path := range settings.CABundlePaths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to read CA bundle %s", path)
+			}
+			if !rootCAs.AppendCertsFromPEM(data) {
+				return nil, errors.Errorf("no certificate found in CA bundle %s", path)
+			}
+		}
+		policy.rootCAs = rootCAs
+	}
+
+	for _, name := range settings.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, errors.Errorf("unknown cipher suite %s", name)
+		}
+		policy.cipherSuites = append(policy.cipherSuites, id)
+	}
+
+	for _, entry := range settings.Pins {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid pin %s, expected host=<base64 SHA-256>", entry)
+		}
+		pin, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(pin) != sha256.Size {
+			return nil, errors.Errorf("invalid pin for %s, expected a base64 SHA-256", parts[0])
+		}
+		host := strings.ToLower(parts[0])
+		policy.pins[host] = append(policy.pins[host], parts[1])
+	}
+
+	return policy, nil
+}
+
+// apply returns the TLS settings of a connection with the policy applied on top of tlsConfig, which can be nil
+func (policy *TLSPolicy) apply(tlsConfig *tls.Config) *tls.Config {
+	if policy == nil {
+		return tlsConfig
+	}
+
+	config := &tls.Config{}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if config.RootCAs == nil {
+		config.RootCAs = policy.rootCAs
+	}
+	config.MinVersion = policy.minVersion
+	config.CipherSuites = policy.cipherSuites
+	if len(policy.pins) > 0 {
+		config.VerifyPeerCertificate = policy.verifyPins
+	}
+	return config
+}
+
+// verifyPins checks a verified chain whose certificate is valid for a pinned host contains one of its pinned keys.
+// The pins are looked up from the certificate as the host connected to is not given to the verification.
+func (policy *TLSPolicy) verifyPins(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		return nil
+	}
+
+	var hosts, pins []string
+	for host, hostPins := range policy.pins {
+		if verifiedChains[0][0].VerifyHostname(host) == nil {
+			hosts = append(hosts, host)
+			pins = append(pins, hostPins...)
+		}
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+
+	for _, chain := range verifiedChains {
+		for _, certificate := range chain {
+			if containsString(pins, spkiPin(certificate)) {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("certificate of %s does not match its pinned keys", strings.Join(hosts, ", "))
+}
+
+// spkiPin returns the base64 SHA-256 of the certificate public key, the format of the pins
+func spkiPin(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
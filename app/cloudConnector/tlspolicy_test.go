@@ -0,0 +1,92 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTLSPolicy(t *testing.T, settings TLSPolicySettings) *TLSPolicy {
+	policy, err := NewTLSPolicy(settings)
+	if err != nil {
+		t.Fatalf("Unable to create TLS policy: %v", err)
+	}
+	return policy
+}
+
+func TestTLSPolicyTrustsCABundleAndPins(t *testing.T) {
+	defer SetTLSPolicy(nil)
+
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	defer testServer.Close()
+
+	dir, err := ioutil.TempDir("", "tlspolicy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := Webhook{URL: testServer.URL, Method: http.MethodGet}
+	if _, err := ProcessWebhook(webhook, ""); err == nil {
+		t.Error("Expected the test server certificate to be untrusted without the CA bundle")
+	}
+
+	SetTLSPolicy(newTestTLSPolicy(t, TLSPolicySettings{CABundlePaths: []string{bundle}, MinVersion: "1.2"}))
+	if _, err := ProcessWebhook(webhook, ""); err != nil {
+		t.Errorf("Expected the CA bundle to be trusted, received %v", err)
+	}
+
+	serverURL, _ := url.Parse(testServer.URL)
+	pin := serverURL.Hostname() + "=" + spkiPin(testServer.Certificate())
+	SetTLSPolicy(newTestTLSPolicy(t, TLSPolicySettings{CABundlePaths: []string{bundle}, Pins: []string{pin}}))
+	if _, err := ProcessWebhook(webhook, ""); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, received %v", err)
+	}
+
+	otherPin := serverURL.Hostname() + "=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	SetTLSPolicy(newTestTLSPolicy(t, TLSPolicySettings{CABundlePaths: []string{bundle}, Pins: []string{otherPin}}))
+	if _, err := ProcessWebhook(webhook, ""); err == nil {
+		t.Error("Expected a key that is not pinned to be rejected")
+	}
+}
+
+func TestTLSPolicyVersionAndCipherSuites(t *testing.T) {
+	policy := newTestTLSPolicy(t, TLSPolicySettings{
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	config := policy.apply(&tls.Config{ServerName: "ingest.example.com"})
+	if config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != 1 ||
+		config.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || config.ServerName != "ingest.example.com" {
+		t.Errorf("Expected the policy to complete the settings of the call, received %+v", config)
+	}
+
+	invalid := []TLSPolicySettings{
+		{MinVersion: "1.4"},
+		{CipherSuites: []string{"TLS_NOT_A_SUITE"}},
+		{Pins: []string{"ingest.example.com"}},
+		{Pins: []string{"ingest.example.com=bm90IGEgc2hhMjU2"}},
+		{CABundlePaths: []string{"/does/not/exist.pem"}},
+	}
+	for _, settings := range invalid {
+		if _, err := NewTLSPolicy(settings); err == nil {
+			t.Errorf("Expected %+v to be rejected", settings)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+)
+
+const (
+	// tokenCacheCapacity bounds the number of cached tokens, the least recently used ones are evicted first
+	tokenCacheCapacity = 1024
+	// Tokens are refreshed once a tenth of their lifetime is left, but never earlier than maxTokenRefreshMargin
+	maxTokenRefreshMargin = time.Minute
+)
+
+// tokenCacheKey identifies the tokens issued for an auth endpoint, client and scope
+type tokenCacheKey struct {
+	endpoint string
+	client   string
+	scope    string
+	audience string
+}
+
+// newTokenCacheKey builds the cache key of the auth settings.
+// JWT signing keys, refresh tokens and the Data of legacy settings are hashed into the client so distinct credentials do not share tokens.
+func newTokenCacheKey(auth Auth) tokenCacheKey {
+	client := auth.ClientID
+	if auth.PrivateKey != "" || auth.ServiceAccount != "" {
+		client += "/jwt:" + hashSecret(auth.Issuer+"\n"+auth.Subject+"\n"+auth.PrivateKey+"\n"+auth.ServiceAccount)
+	} else if auth.RefreshToken != "" {
+		client += "/refresh:" + hashSecret(auth.RefreshToken)
+	} else if client == "" && auth.Data != "" {
+		client = "data:" + hashSecret(auth.Data)
+	}
+	return tokenCacheKey{
+		endpoint: auth.Endpoint,
+		client:   client,
+		scope:    auth.Scope,
+		audience: auth.Audience,
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type tokenCacheEntry struct {
+	key   tokenCacheKey
+	token *accessToken
+}
+
+// tokenFetch is a token request shared by all the callers waiting for the same key
+type tokenFetch struct {
+	done  chan struct{}
+	token *accessToken
+	err   error
+}
+
+// tokenCache is a bounded LRU cache of access tokens. Concurrent requests for the same key
+// are coalesced into a single call to the authorization server.
+type tokenCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[tokenCacheKey]*list.Element
+	lru      *list.List
+	fetches  map[tokenCacheKey]*tokenFetch
+}
+
+func newTokenCache(capacity int) *tokenCache {
+	return &tokenCache{
+		capacity: capacity,
+		entries:  make(map[tokenCacheKey]*list.Element),
+		lru:      list.New(),
+		fetches:  make(map[tokenCacheKey]*tokenFetch),
+	}
+}
+
+// get returns the cached token of the key, calling fetch when it is missing or due for refresh.
+// A token due for refresh is still handed out while another caller is fetching its replacement.
+func (cache *tokenCache) get(key tokenCacheKey, fetch func() (*accessToken, error)) (*accessToken, error) {
+	cache.mutex.Lock()
+	cached := cache.lookup(key)
+	if cached.fresh() {
+		cache.mutex.Unlock()
+		return cached, nil
+	}
+
+	if pending, ok := cache.fetches[key]; ok {
+		cache.mutex.Unlock()
+		if cached.valid() {
+			return cached, nil
+		}
+		metrics.GetOrRegisterGauge("CloudConnector.tokenCache.Coalesced", nil).Update(1)
+		<-pending.done
+		return pending.token, pending.err
+	}
+
+	pending := &tokenFetch{done: make(chan struct{})}
+	cache.fetches[key] = pending
+	cache.mutex.Unlock()
+
+	pending.token, pending.err = fetch()
+
+	cache.mutex.Lock()
+	delete(cache.fetches, key)
+	if pending.err == nil && pending.token.valid() {
+		cache.store(key, pending.token)
+	}
+	cache.mutex.Unlock()
+	close(pending.done)
+
+	return pending.token, pending.err
+}
+
+// invalidate drops the token of the key, ex. after the webhook rejected it
+func (cache *tokenCache) invalidate(key tokenCacheKey) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *tokenCache) len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.lru.Len()
+}
+
+func (cache *tokenCache) lookup(key tokenCacheKey) *accessToken {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if !entry.token.valid() {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+	return entry.token
+}
+
+func (cache *tokenCache) store(key tokenCacheKey, token *accessToken) {
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*tokenCacheEntry).token = token
+		cache.lru.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.lru.PushFront(&tokenCacheEntry{key: key, token: token})
+	for cache.lru.Len() > cache.capacity {
+		oldest := cache.lru.Remove(cache.lru.Back()).(*tokenCacheEntry)
+		delete(cache.entries, oldest.key)
+	}
+}
+
+// fresh reports whether the token is valid and not yet due for refresh
+func (token *accessToken) fresh() bool {
+	return token.valid() && token.RefreshDate > helper.UnixMilliNow()
+}
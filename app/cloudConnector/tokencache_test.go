@@ -0,0 +1,102 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
+)
+
+func newTestAccessToken(value string, expiresIn time.Duration, refreshIn time.Duration) *accessToken {
+	now := helper.UnixMilliNow()
+	return &accessToken{
+		TokenType:      "Bearer",
+		AccessToken:    value,
+		ExpirationDate: now + int64(expiresIn/time.Millisecond),
+		RefreshDate:    now + int64(refreshIn/time.Millisecond),
+	}
+}
+
+func TestTokenCacheCoalescesConcurrentFetches(t *testing.T) {
+	cache := newTokenCache(10)
+	key := newTokenCacheKey(Auth{Endpoint: "http://idp/oauth", ClientID: "connector"})
+
+	var fetches int32
+	fetch := func() (*accessToken, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		return newTestAccessToken("token", time.Hour, time.Hour), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := cache.get(key, fetch)
+			if err != nil || token.AccessToken != "token" {
+				t.Errorf("Expected the shared token, received %v %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("Expected a single token request, made %d", fetches)
+	}
+}
+
+func TestTokenCacheRefreshesEarly(t *testing.T) {
+	cache := newTokenCache(10)
+	key := newTokenCacheKey(Auth{Endpoint: "http://idp/oauth", ClientID: "connector"})
+
+	// Still valid for a minute but already due for refresh
+	cache.store(key, newTestAccessToken("stale", time.Minute, -time.Second))
+
+	token, err := cache.get(key, func() (*accessToken, error) {
+		return newTestAccessToken("refreshed", time.Hour, time.Hour), nil
+	})
+	if err != nil || token.AccessToken != "refreshed" {
+		t.Errorf("Expected the token to be refreshed before it expires, received %v %v", token, err)
+	}
+}
+
+func TestTokenCacheKeysAndEviction(t *testing.T) {
+	cache := newTokenCache(2)
+	auth := Auth{Endpoint: "http://idp/oauth", ClientID: "connector", Scope: "read"}
+	other := auth
+	other.Scope = "write"
+	legacy := Auth{Endpoint: "http://idp/oauth", Data: "Basic abc"}
+
+	if newTokenCacheKey(auth) == newTokenCacheKey(other) {
+		t.Error("Expected different scopes to use different tokens")
+	}
+	if newTokenCacheKey(legacy) == newTokenCacheKey(Auth{Endpoint: "http://idp/oauth", Data: "Basic xyz"}) {
+		t.Error("Expected different legacy credentials to use different tokens")
+	}
+
+	for _, value := range []Auth{auth, other, legacy} {
+		_, _ = cache.get(newTokenCacheKey(value), func() (*accessToken, error) {
+			return newTestAccessToken("token-"+value.Scope, time.Hour, time.Hour), nil
+		})
+	}
+	if cache.len() != 2 {
+		t.Fatalf("Expected the cache to be bounded to 2 tokens, holds %d", cache.len())
+	}
+	if cache.lookup(newTokenCacheKey(auth)) != nil {
+		t.Error("Expected the least recently used token to be evicted")
+	}
+
+	cache.invalidate(newTokenCacheKey(legacy))
+	if cache.lookup(newTokenCacheKey(legacy)) != nil {
+		t.Error("Expected the invalidated token to be dropped")
+	}
+}
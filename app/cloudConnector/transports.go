@@ -0,0 +1,195 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+)
+
+const (
+	// transportCapacity bounds the number of pooled clients, the least recently used ones are closed first
+	transportCapacity = 256
+
+	dialTimeout           = 30 * time.Second
+	dialKeepAlive         = 30 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	expectContinueTimeout = time.Second
+)
+
+// TransportSettings sizes the connection pools shared by the outbound calls
+type TransportSettings struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts of a pool, 0 means no limit
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host, 0 uses the Go default of 2
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections per host, including the ones in use, 0 means no limit
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections left idle for longer, 0 keeps them open
+	IdleConnTimeout time.Duration
+}
+
+// DefaultTransportSettings matches the pool of the Go default transport
+func DefaultTransportSettings() TransportSettings {
+	return TransportSettings{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+var transports = newTransportManager(DefaultTransportSettings(), transportCapacity)
+
+// SetTransportSettings sizes the connection pools, pooled connections are closed and reopened with the new settings
+func SetTransportSettings(settings TransportSettings) {
+	transports.configure(settings)
+}
+
+// transportKey identifies the clients that can share connections.
+// The client certificate is hashed so the key never holds the private key.
+type transportKey struct {
+	timeout    time.Duration
+	proxy      string
+	direct     bool
+	clientCert string
+}
+
+type transportEntry struct {
+	key    transportKey
+	client *http.Client
+}
+
+// transportManager is a bounded LRU pool of HTTP clients, so calls with the same proxy, TLS settings
+// and timeout reuse their keep-alive connections and TLS sessions
+type transportManager struct {
+	mutex    sync.Mutex
+	settings TransportSettings
+	capacity int
+	entries  map[transportKey]*list.Element
+	lru      *list.List
+}
+
+func newTransportManager(settings TransportSettings, capacity int) *transportManager {
+	return &transportManager{
+		settings: settings,
+		capacity: capacity,
+		entries:  make(map[transportKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the pooled client of the timeout, proxy and client certificate of auth, creating it when missing.
+// Without a proxy the client uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func (manager *transportManager) get(timeout time.Duration, proxy string, auth Auth) (*http.Client, error) {
+	return manager.client(transportKey{timeout: timeout, proxy: proxy}, auth)
+}
+
+// getDirect returns the pooled client of the timeout and client certificate of auth that never goes through a proxy
+func (manager *transportManager) getDirect(timeout time.Duration, auth Auth) (*http.Client, error) {
+	return manager.client(transportKey{timeout: timeout, direct: true}, auth)
+}
+
+func (manager *transportManager) client(key transportKey, auth Auth) (*http.Client, error) {
+	if auth.ClientCert != "" || auth.ClientKey != "" {
+		key.clientCert = hashSecret(auth.ClientCert + "\n" + auth.ClientKey)
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if element, ok := manager.entries[key]; ok {
+		manager.lru.MoveToFront(element)
+		return element.Value.(*transportEntry).client, nil
+	}
+
+	client, err := manager.newClient(key, auth)
+	if err != nil {
+		return nil, err
+	}
+	metrics.GetOrRegisterGauge("CloudConnector.Transports.Created", nil).Update(1)
+
+	manager.entries[key] = manager.lru.PushFront(&transportEntry{key: key, client: client})
+	for manager.lru.Len() > manager.capacity {
+		oldest := manager.lru.Remove(manager.lru.Back()).(*transportEntry)
+		delete(manager.entries, oldest.key)
+		oldest.client.Transport.(*http.Transport).CloseIdleConnections()
+	}
+	return client, nil
+}
+
+func (manager *transportManager) newClient(key transportKey, auth Auth) (*http.Client, error) {
+	tlsConfig, err := clientTLSConfig(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: dialKeepAlive,
+		}).DialContext,
+		// The service wide TLS policy completes the settings of the call
+		TLSClientConfig:       tlsPolicy.apply(tlsConfig),
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+		MaxIdleConns:          manager.settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   manager.settings.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       manager.settings.MaxConnsPerHost,
+		IdleConnTimeout:       manager.settings.IdleConnTimeout,
+	}
+	if key.direct {
+		transport.Proxy = nil
+	} else if key.proxy != "" {
+		proxyURL, parseErr := url.Parse(key.proxy)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Timeout:   key.timeout,
+		Transport: transport,
+	}, nil
+}
+
+// configure changes the pool settings of the clients created from now on and drops the pooled ones
+func (manager *transportManager) configure(settings TransportSettings) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.settings = settings
+	manager.clear()
+}
+
+// flush drops the pooled clients, ex. once the TLS policy they were created with changed
+func (manager *transportManager) flush() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.clear()
+}
+
+// clear closes the pooled connections, the clients are recreated on their next use
+func (manager *transportManager) clear() {
+	for element := manager.lru.Front(); element != nil; element = element.Next() {
+		element.Value.(*transportEntry).client.Transport.(*http.Transport).CloseIdleConnections()
+	}
+	manager.entries = make(map[transportKey]*list.Element)
+	manager.lru = list.New()
+}
+
+func (manager *transportManager) len() int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.lru.Len()
+}
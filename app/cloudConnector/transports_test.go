@@ -0,0 +1,155 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package cloudConnector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportManagerReusesClients(t *testing.T) {
+	manager := newTransportManager(DefaultTransportSettings(), 2)
+	clientCert, clientKey := newTestClientCertificate(t, "store-105")
+
+	first, err := manager.get(time.Second, "", Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := manager.get(time.Second, "", Auth{AuthType: "bearer", Token: "other"})
+	if first != same {
+		t.Error("Expected calls with the same timeout, proxy and TLS settings to share a client")
+	}
+
+	withCert, err := manager.get(time.Second, "", Auth{ClientCert: clientCert, ClientKey: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withCert == first {
+		t.Error("Expected a client certificate to use its own client")
+	}
+	if _, err := manager.get(time.Second, "://bad proxy", Auth{}); err == nil {
+		t.Error("Expected an invalid proxy URL to be rejected")
+	}
+	if _, err := manager.get(time.Second, "", Auth{ClientCert: clientCert}); err == nil {
+		t.Error("Expected a client certificate without its key to be rejected")
+	}
+
+	// Only the 2 most recently used clients are kept
+	if _, err := manager.get(2*time.Second, "", Auth{}); err != nil {
+		t.Fatal(err)
+	}
+	if manager.len() != 2 {
+		t.Errorf("Expected the pool to be bounded to 2 clients, holds %d", manager.len())
+	}
+	if again, _ := manager.get(time.Second, "", Auth{}); again == first {
+		t.Error("Expected the least recently used client to be evicted")
+	}
+
+	manager.configure(TransportSettings{MaxIdleConnsPerHost: 1, MaxConnsPerHost: 4, IdleConnTimeout: time.Second})
+	client, _ := manager.get(time.Second, "http://proxy:3128", Auth{})
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 1 || transport.MaxConnsPerHost != 4 || transport.IdleConnTimeout != time.Second || transport.Proxy == nil {
+		t.Errorf("Expected the new pool settings to be used, received %+v", transport)
+	}
+
+	direct, err := manager.getDirect(time.Second, Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direct == first || direct.Transport.(*http.Transport).Proxy != nil {
+		t.Error("Expected a direct client to bypass the proxy of the environment")
+	}
+}
+
+func TestTransportManagerKeepsConnectionsAlive(t *testing.T) {
+	defer SetTransportSettings(DefaultTransportSettings())
+	SetTransportSettings(DefaultTransportSettings())
+
+	var connections int32
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	testServer.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	testServer.Start()
+	defer testServer.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := ProcessWebhook(Webhook{URL: testServer.URL, Method: http.MethodPost, Payload: "sku"}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if connections != 1 {
+		t.Errorf("Expected the webhook calls to share a single connection, opened %d", connections)
+	}
+}
+
+// newBenchmarkServer starts a TLS server trusted through the TLS policy, as most cloud endpoints are served over TLS
+func newBenchmarkServer(b *testing.B) *httptest.Server {
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, request.Body)
+	}))
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(testServer.Certificate())
+	SetTLSPolicy(&TLSPolicy{rootCAs: rootCAs})
+	return testServer
+}
+
+// BenchmarkWebhookPooledTransport measures webhook calls sharing the pooled transports
+func BenchmarkWebhookPooledTransport(b *testing.B) {
+	testServer := newBenchmarkServer(b)
+	defer testServer.Close()
+	defer SetTLSPolicy(nil)
+
+	webhook := Webhook{URL: testServer.URL, Method: http.MethodPost, Payload: map[string]string{"sku": "1"}}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ProcessWebhook(webhook, ""); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkWebhookTransportPerCall measures the previous behavior of a new transport on every call,
+// which pays for a TCP connection and a TLS handshake each time
+func BenchmarkWebhookTransportPerCall(b *testing.B) {
+	testServer := newBenchmarkServer(b)
+	defer testServer.Close()
+	defer SetTLSPolicy(nil)
+
+	webhook := Webhook{URL: testServer.URL, Method: http.MethodPost, Payload: map[string]string{"sku": "1"}}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			transport := &http.Transport{TLSClientConfig: tlsPolicy.apply(&tls.Config{})}
+			client := &http.Client{Timeout: webhookConnectionTimeout * time.Second, Transport: transport}
+			request, _, err := newWebhookRequest(webhook)
+			if err != nil {
+				b.Fatal(err)
+			}
+			response, err := client.Do(request)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			_, _ = io.Copy(ioutil.Discard, response.Body)
+			_ = response.Body.Close()
+			transport.CloseIdleConnections()
+		}
+	})
+}
@@ -7,18 +7,47 @@
 package config
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/configuration"
 	"github.com/pkg/errors"
 )
 
 type (
 	variables struct {
-		ServiceName            string
-		LoggingLevel           string
-		HttpsProxyURL          string
-		Port                   string
-		TelemetryEndpoint      string
-		TelemetryDataStoreName string
+		ServiceName             string
+		LoggingLevel            string
+		HttpsProxyURL           string
+		Port                    string
+		TelemetryEndpoint       string
+		TelemetryDataStoreName  string
+		DeliveryQueuePath       string
+		DeliveryRetryInterval   int
+		DeliveryMaxAttempts     int
+		DeadLetterPath          string
+		RefreshTokenPath        string
+		CredentialsPath         string
+		AllowInlineSecrets      bool
+		EncryptionKeyPath       string
+		EncryptionKeys          string
+		TLSCABundlePaths        []string
+		TLSMinVersion           string
+		TLSCipherSuites         []string
+		TLSPins                 []string
+		HTTPMaxIdleConns        int
+		HTTPMaxIdleConnsPerHost int
+		HTTPMaxConnsPerHost     int
+		HTTPIdleConnTimeout     int
+		S3CheckBucket           bool
+		AwsDefaultCredentials   bool
+		JobHistorySize          int
+		RetryMaxAttempts        int
+		RetryInitialBackoff     int
+		RetryMaxBackoff         int
+		RetryJitter             float64
+		RetryableStatusCodes    []int
+		RetryableErrors         []string
 	}
 )
 
@@ -61,6 +90,144 @@ func InitConfig(configChangedCallback func([]configuration.ChangeDetails)) error
 		return errors.Wrapf(err, "Unable to load config variables")
 	}
 
+	AppConfig.DeliveryQueuePath, err = config.GetString("deliveryQueuePath")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.DeliveryRetryInterval, err = config.GetInt("deliveryRetryInterval")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.DeliveryMaxAttempts, err = config.GetInt("deliveryMaxAttempts")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.DeadLetterPath, err = config.GetString("deadLetterPath")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RefreshTokenPath, err = config.GetString("refreshTokenPath")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.CredentialsPath, err = config.GetString("credentialsPath")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.AllowInlineSecrets, err = config.GetBool("allowInlineSecrets")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.EncryptionKeyPath, err = config.GetString("encryptionKeyPath")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.EncryptionKeys, err = config.GetString("encryptionKeys")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.TLSCABundlePaths, err = config.GetStringSlice("tlsCABundlePaths")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+	AppConfig.TLSCABundlePaths = trimEmpty(AppConfig.TLSCABundlePaths)
+
+	AppConfig.TLSMinVersion, err = config.GetString("tlsMinVersion")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.TLSCipherSuites, err = config.GetStringSlice("tlsCipherSuites")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+	AppConfig.TLSCipherSuites = trimEmpty(AppConfig.TLSCipherSuites)
+
+	AppConfig.TLSPins, err = config.GetStringSlice("tlsPins")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+	AppConfig.TLSPins = trimEmpty(AppConfig.TLSPins)
+
+	AppConfig.HTTPMaxIdleConns, err = config.GetInt("httpMaxIdleConns")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.HTTPMaxIdleConnsPerHost, err = config.GetInt("httpMaxIdleConnsPerHost")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.HTTPMaxConnsPerHost, err = config.GetInt("httpMaxConnsPerHost")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.HTTPIdleConnTimeout, err = config.GetInt("httpIdleConnTimeout")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.S3CheckBucket, err = config.GetBool("s3CheckBucket")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.AwsDefaultCredentials, err = config.GetBool("awsDefaultCredentials")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.JobHistorySize, err = config.GetInt("jobHistorySize")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RetryMaxAttempts, err = config.GetInt("retryMaxAttempts")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RetryInitialBackoff, err = config.GetInt("retryInitialBackoff")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RetryMaxBackoff, err = config.GetInt("retryMaxBackoff")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RetryJitter, err = config.GetFloat("retryJitter")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	retryableStatusCodes, err := config.GetStringSlice("retryableStatusCodes")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+	AppConfig.RetryableStatusCodes, err = toIntSlice(retryableStatusCodes)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+
+	AppConfig.RetryableErrors, err = config.GetStringSlice("retryableErrors")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to load config variables")
+	}
+	AppConfig.RetryableErrors = trimEmpty(AppConfig.RetryableErrors)
+
 	// Set "debug" for development purposes. Nil for Production.
 	AppConfig.LoggingLevel, err = config.GetString("loggingLevel")
 	if err != nil {
@@ -69,3 +236,27 @@ func InitConfig(configChangedCallback func([]configuration.ChangeDetails)) error
 
 	return nil
 }
+
+// toIntSlice converts config values such as status codes, which are stored as strings so they can be set from the environment
+func toIntSlice(values []string) ([]int, error) {
+	var ints []int
+	for _, value := range trimEmpty(values) {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid number %s", value)
+		}
+		ints = append(ints, number)
+	}
+	return ints, nil
+}
+
+// trimEmpty drops the blank entries an empty environment variable turns into
+func trimEmpty(values []string) []string {
+	var trimmed []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
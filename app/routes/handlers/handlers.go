@@ -10,29 +10,41 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/gorilla/mux"
 
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/cloudConnector"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/config"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/pkg/web"
 	"github.com/intel/rsp-sw-toolkit-im-suite-gojsonschema"
 	metrics "github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
-	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/helper"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 // CloudConnector represents the User API method handler set.
 type CloudConnector struct {
+	// Deliveries holds the async webhook calls until they reach the cloud
+	Deliveries *cloudConnector.DeliveryQueue
+	// DeadLetters holds the async webhook calls that failed for good
+	DeadLetters *cloudConnector.DeadLetterStore
+	// Jobs tracks the status of the async webhook calls
+	Jobs *cloudConnector.JobTracker
+}
+
+// JobResponse is returned for async webhook calls, the job ID can be used to follow the delivery at /jobs/{id}
+type JobResponse struct {
+	JobID string `json:"jobid"`
 }
 
 // Response wraps results, inlinecount, and extra fields in a json object
@@ -67,7 +79,8 @@ func (connector *CloudConnector) Index(ctx context.Context, writer http.Response
 }
 
 //CallWebhook
-// 200 OK, 400 Bad Request, 404 endpoint not found, 500 Internal Error
+// 200 OK, 400 Bad Request, 404 endpoint not found, 500 Internal Error,
+// 502 Bad Gateway or 504 Gateway Timeout when the destination still fails once the retries are exhausted
 func (connector *CloudConnector) CallWebhook(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
 
 	traceID := ctx.Value(web.KeyValues).(*web.ContextValues).TraceID
@@ -76,7 +89,9 @@ func (connector *CloudConnector) CallWebhook(ctx context.Context, writer http.Re
 	metrics.GetOrRegisterGauge("CloudConnector.callwebhook.Attempt", nil).Update(1)
 
 	startTime := time.Now()
-	defer metrics.GetOrRegisterTimer("CloudConnector.callwebhook.Latency", nil).Update(time.Since(startTime))
+	defer func() {
+		metrics.GetOrRegisterTimer("CloudConnector.callwebhook.Latency", nil).Update(time.Since(startTime))
+	}()
 	var webHookObj cloudConnector.Webhook
 
 	validationErrors, marshalError := unmarshalRequestBody(writer, request, &webHookObj, cloudConnector.WebhookSchema)
@@ -110,13 +125,29 @@ func (connector *CloudConnector) CallWebhook(ctx context.Context, writer http.Re
 		return nil
 	}
 
-	//Get call always has an response object, so isAsync flag will be ignored even if set
-	if webHookObj.IsAsync && webHookObj.Method == http.MethodPost {
-		go cloudCall(ctx, writer, webHookObj)
-		web.Respond(ctx, writer, nil, http.StatusOK)
+	if err := cloudConnector.CheckWebhookCredentials(webHookObj); err != nil {
+		log.WithFields(log.Fields{
+			"Method": "CallWebhook",
+			"Action": "POST/GET notification to webhooks",
+			"Code":   http.StatusBadRequest,
+		}).Error(err.Error())
+		web.Respond(ctx, writer, credentialErrors(err), http.StatusBadRequest)
+		return nil
+	}
+
+	webHookObj.Retry = webHookObj.Retry.WithDefaults(defaultRetryPolicy())
+
+	//GET, HEAD and OPTIONS calls always have a response object, so isAsync flag will be ignored even if set
+	if webHookObj.IsAsync && !cloudConnector.IsSafeMethod(webHookObj.Method) {
+		// Async deliveries are persisted first so they are not lost if the cloud is unreachable or the service restarts
+		if err := connector.Deliveries.Enqueue(traceID, webHookObj); err != nil {
+			return errors.Wrap(err, "unable to queue async webhook")
+		}
+		writer.Header().Set("Location", "/jobs/"+traceID)
+		web.Respond(ctx, writer, JobResponse{JobID: traceID}, http.StatusOK)
 
 	} else {
-		//In case if GET calls IsAsync option is set to true by mistake, we reset it back to false.
+		//In case if GET, HEAD or OPTIONS calls IsAsync option is set to true by mistake, we reset it back to false.
 		webHookObj.IsAsync = false
 		cloudCall(ctx, writer, webHookObj)
 	}
@@ -127,7 +158,9 @@ func cloudCall(ctx context.Context, writer http.ResponseWriter, webHookObj cloud
 
 	traceID := ctx.Value(web.KeyValues).(*web.ContextValues).TraceID
 	startTime := time.Now()
-	defer metrics.GetOrRegisterTimer("CloudConnector.syncCloudCall.Latency", nil).Update(time.Since(startTime))
+	defer func() {
+		metrics.GetOrRegisterTimer("CloudConnector.syncCloudCall.Latency", nil).Update(time.Since(startTime))
+	}()
 	mSuccess := metrics.GetOrRegisterGauge("CloudConnector.syncCloudCall.Success", nil)
 	mError := metrics.GetOrRegisterGauge("CloudConnector.syncCloudCall.Error", nil)
 
@@ -141,9 +174,7 @@ func cloudCall(ctx context.Context, writer http.ResponseWriter, webHookObj cloud
 			"TraceID":       traceID,
 		}).Error(err.Error())
 
-		if !webHookObj.IsAsync {
-			web.Respond(ctx, writer, err, http.StatusNotFound)
-		}
+		web.Respond(ctx, writer, err, webhookFailureStatus(response, err))
 		mError.Update(1)
 	} else {
 		log.WithFields(log.Fields{
@@ -154,14 +185,173 @@ func cloudCall(ctx context.Context, writer http.ResponseWriter, webHookObj cloud
 			"webhookURL":    webHookObj.URL,
 		}).Debug("Successful!")
 
-		//If its not a async request, then set a response for GET request
-		if !webHookObj.IsAsync {
-			web.Respond(ctx, writer, response, http.StatusOK)
-		}
+		web.Respond(ctx, writer, response, http.StatusOK)
 		mSuccess.Update(1)
 	}
 }
 
+// webhookFailureStatus returns the status of a failed webhook call: 504 when the destination timed out,
+// 502 when it could not be reached or answered with a server error, and 404 for anything else
+func webhookFailureStatus(response *cloudConnector.WebhookResponse, err error) int {
+	switch {
+	case cloudConnector.IsTimeoutError(err):
+		return http.StatusGatewayTimeout
+	case response != nil && response.StatusCode >= http.StatusInternalServerError:
+		return http.StatusBadGateway
+	case response == nil && cloudConnector.IsTransportError(err):
+		return http.StatusBadGateway
+	}
+	return http.StatusNotFound
+}
+
+// GetJob returns the status of an async webhook call
+// 200 OK, 404 Not Found, 500 Internal Error
+func (connector *CloudConnector) GetJob(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	id := mux.Vars(request)["id"]
+
+	job, ok := connector.Jobs.Get(id)
+	if !ok {
+		// Jobs that failed before the last restart are only known to the dead-letter store
+		deadLetter, err := connector.DeadLetters.Get(id)
+		if err != nil {
+			return deadLetterError(err)
+		}
+		job = cloudConnector.Job{
+			ID:         deadLetter.ID,
+			Status:     cloudConnector.JobFailed,
+			Attempts:   len(deadLetter.Attempts),
+			StatusCode: deadLetter.LastStatusCode,
+			CreatedAt:  deadLetter.EnqueuedAt,
+			UpdatedAt:  deadLetter.FailedAt,
+		}
+		if len(deadLetter.Attempts) > 0 {
+			job.Error = deadLetter.Attempts[len(deadLetter.Attempts)-1].Error
+		}
+	}
+
+	web.Respond(ctx, writer, job, http.StatusOK)
+	return nil
+}
+
+// ListDeadLetters returns every failed async delivery, with the secrets of the webhooks redacted
+// 200 OK, 500 Internal Error
+func (connector *CloudConnector) ListDeadLetters(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	deadLetters, err := connector.DeadLetters.List()
+	if err != nil {
+		return err
+	}
+	for i := range deadLetters {
+		deadLetters[i] = deadLetters[i].Redacted()
+	}
+	web.Respond(ctx, writer, Response{Results: deadLetters, Count: len(deadLetters)}, http.StatusOK)
+	return nil
+}
+
+// GetDeadLetter returns a single failed async delivery, with the secrets of the webhook redacted
+// 200 OK, 404 Not Found, 500 Internal Error
+func (connector *CloudConnector) GetDeadLetter(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	deadLetter, err := connector.DeadLetters.Get(mux.Vars(request)["id"])
+	if err != nil {
+		return deadLetterError(err)
+	}
+	web.Respond(ctx, writer, deadLetter.Redacted(), http.StatusOK)
+	return nil
+}
+
+// ReplayDeadLetter puts a failed async delivery back into the delivery queue
+// 200 OK, 404 Not Found, 500 Internal Error
+func (connector *CloudConnector) ReplayDeadLetter(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	traceID := ctx.Value(web.KeyValues).(*web.ContextValues).TraceID
+	id := mux.Vars(request)["id"]
+
+	deadLetter, err := connector.DeadLetters.Get(id)
+	if err != nil {
+		return deadLetterError(err)
+	}
+
+	// The dead letter is claimed before queueing, as the replay may fail again and store a new one under the same ID
+	if err := connector.DeadLetters.Remove(id); err != nil {
+		return deadLetterError(err)
+	}
+	if err := connector.Deliveries.Enqueue(deadLetter.ID, deadLetter.Webhook); err != nil {
+		if addErr := connector.DeadLetters.Add(*deadLetter); addErr != nil {
+			log.WithFields(log.Fields{
+				"Method":     "ReplayDeadLetter",
+				"DeadLetter": id,
+				"TraceID":    traceID,
+				"Error":      addErr.Error(),
+			}).Error("Unable to restore the dead letter, it is lost")
+		}
+		return errors.Wrap(err, "unable to queue dead letter")
+	}
+
+	log.WithFields(log.Fields{
+		"Method":     "ReplayDeadLetter",
+		"DeadLetter": id,
+		"TraceID":    traceID,
+	}).Info("Dead letter queued for replay")
+
+	web.Respond(ctx, writer, nil, http.StatusOK)
+	return nil
+}
+
+// DeleteDeadLetter removes a single failed async delivery
+// 204 No Content, 404 Not Found, 500 Internal Error
+func (connector *CloudConnector) DeleteDeadLetter(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	if err := connector.DeadLetters.Remove(mux.Vars(request)["id"]); err != nil {
+		return deadLetterError(err)
+	}
+	web.Respond(ctx, writer, nil, http.StatusNoContent)
+	return nil
+}
+
+// PurgeDeadLetters removes every failed async delivery
+// 200 OK, 500 Internal Error
+func (connector *CloudConnector) PurgeDeadLetters(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	purged, err := connector.DeadLetters.Purge()
+	if err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, Response{Count: purged}, http.StatusOK)
+	return nil
+}
+
+func deadLetterError(err error) error {
+	if err == cloudConnector.ErrDeadLetterNotFound {
+		return web.ErrNotFound
+	}
+	return err
+}
+
+// credentialErrors reports a credential that cannot be resolved like a schema validation error
+func credentialErrors(err error) []ErrReport {
+	report := ErrReport{
+		Field:       "credentialref",
+		ErrorType:   "credential",
+		Description: err.Error(),
+	}
+	if errors.Cause(err) == cloudConnector.ErrInlineSecretsDisabled {
+		report.Field = "auth"
+		report.ErrorType = "inline_secret"
+	}
+	if errors.Cause(err) == cloudConnector.ErrAwsDefaultCredentialsDisabled {
+		report.Field = "accesskeyid"
+	}
+	return []ErrReport{report}
+}
+
+// defaultRetryPolicy returns the service wide retry settings used for anything the webhook does not specify
+func defaultRetryPolicy() cloudConnector.RetryPolicy {
+	return cloudConnector.RetryPolicy{
+		MaxAttempts:          config.AppConfig.RetryMaxAttempts,
+		InitialBackoff:       config.AppConfig.RetryInitialBackoff,
+		MaxBackoff:           config.AppConfig.RetryMaxBackoff,
+		Jitter:               config.AppConfig.RetryJitter,
+		RetryableStatusCodes: config.AppConfig.RetryableStatusCodes,
+		RetryableErrors:      config.AppConfig.RetryableErrors,
+	}
+}
+
 // AwsCloud triggers a set of rules based on the user input
 // 200 OK, 400 Bad Request, 500 Internal Error
 func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
@@ -171,7 +361,9 @@ func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.Respo
 	metrics.GetOrRegisterMeter("CloudConnector.AwsCloud.Attempt", nil).Mark(1)
 
 	startTime := time.Now()
-	defer metrics.GetOrRegisterTimer("CloudConnector.AwsCloud.Latency", nil).Update(time.Since(startTime))
+	defer func() {
+		metrics.GetOrRegisterTimer("CloudConnector.AwsCloud.Latency", nil).Update(time.Since(startTime))
+	}()
 	mSuccess := metrics.GetOrRegisterMeter("CloudConnector.AwsCloud.Success", nil)
 
 	statusCode := http.StatusOK
@@ -207,6 +399,33 @@ func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.Respo
 		return nil
 	}
 
+	awsConnectionData, err := cloudConnector.ResolveAwsCredentials(awsConnectionData)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Method": "AwsCloud",
+			"Action": "post to aws",
+			"Code":   http.StatusBadRequest,
+		}).Error(err.Error())
+		web.Respond(ctx, writer, credentialErrors(err), http.StatusBadRequest)
+		return nil
+	}
+
+	objectKey, err := cloudConnector.ObjectKey(awsConnectionData.KeyTemplate, awsConnectionData.Payload, traceID, time.Now())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Method": "AwsCloud",
+			"Action": "post to aws",
+			"Code":   http.StatusBadRequest,
+		}).Error(err.Error())
+		web.Respond(ctx, writer, []ErrReport{{
+			Field:       "keytemplate",
+			ErrorType:   "keytemplate",
+			Value:       awsConnectionData.KeyTemplate,
+			Description: err.Error(),
+		}}, http.StatusBadRequest)
+		return nil
+	}
+
 	data, err := json.Marshal(awsConnectionData.Payload)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -218,10 +437,30 @@ func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.Respo
 		return nil
 	}
 
+	awsCredentials, err := cloudConnector.AwsCredentials(awsConnectionData)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Method": "AwsCloud",
+			"Action": "post to aws",
+			"Code":   http.StatusBadRequest,
+		}).Error(err.Error())
+		web.Respond(ctx, writer, credentialErrors(err), http.StatusBadRequest)
+		return nil
+	}
+
 	awsConfig := aws.Config{
-		Region:      &awsConnectionData.Region,
-		Credentials: credentials.NewStaticCredentials(awsConnectionData.AccessKeyID, awsConnectionData.SecretAccessKey, ""),
-		LogLevel:    &logLevel,
+		Region:           &awsConnectionData.Region,
+		Credentials:      awsCredentials,
+		LogLevel:         &logLevel,
+		S3ForcePathStyle: aws.Bool(awsConnectionData.ForcePathStyle),
+		DisableSSL:       aws.Bool(awsConnectionData.DisableSSL),
+	}
+	if awsConnectionData.Endpoint != "" {
+		// S3 compatible stores ignore the region, it is only needed to sign the requests
+		if awsConnectionData.Region == "" {
+			awsConfig.Region = aws.String(defaultS3CompatibleRegion)
+		}
+		awsConfig.Endpoint = aws.String(awsConnectionData.Endpoint)
 	}
 
 	sess, err := session.NewSessionWithOptions(session.Options{
@@ -238,15 +477,24 @@ func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.Respo
 		return nil
 	}
 
+	if awsConnectionData.RoleARN != "" {
+		awsConfig.Credentials = cloudConnector.AssumeAwsRole(sess, awsConnectionData)
+	}
+
 	s3Client := s3.New(sess, &awsConfig)
 
-	if err := s3AddDataToBucket(s3Client, awsConnectionData.Bucket, data); err != nil {
+	if err := s3AddDataToBucket(s3Client, newPutObjectInput(awsConnectionData, objectKey, data), config.AppConfig.S3CheckBucket); err != nil {
+		code := http.StatusBadRequest
+		if errors.Cause(err) == errObjectExists {
+			code = http.StatusConflict
+		}
 		log.WithFields(log.Fields{
 			"Method": "AwsCloud",
 			"Action": "post to aws",
-			"Code":   http.StatusBadRequest,
-		}).Error("Failed creating AWS client")
-		web.Respond(ctx, writer, err, http.StatusBadRequest)
+			"Code":   code,
+			"Error":  err.Error(),
+		}).Error("Failed uploading to AWS")
+		web.Respond(ctx, writer, err, code)
 		return err
 	}
 
@@ -255,61 +503,96 @@ func (connector *CloudConnector) AwsCloud(ctx context.Context, writer http.Respo
 	return nil
 }
 
-func s3AddDataToBucket(s3Client *s3.S3, bucketName string, data []byte) error {
+const (
+	// defaultS3CompatibleRegion signs the requests to an S3 compatible endpoint when no region is given
+	defaultS3CompatibleRegion = "us-east-1"
+	// defaultS3ContentType is the content type of the uploaded payload, which is always json encoded
+	defaultS3ContentType = "application/json"
+)
 
-	objectName := fmt.Sprintf("awsfile_%v", helper.UnixMilliNow())
+// errObjectExists is returned when the object key of an upload is already taken in the bucket
+var errObjectExists = errors.New("object already exists")
+
+// newPutObjectInput returns the upload of the data with the object settings of the request.
+// The object is always private, and a download of it is saved as a file rather than displayed.
+func newPutObjectInput(awsConnectionData cloudConnector.AwsConnectionData, objectKey string, data []byte) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(awsConnectionData.Bucket),
+		Key:                aws.String(objectKey),
+		ACL:                aws.String(s3.ObjectCannedACLPrivate),
+		Body:               bytes.NewReader(data),
+		ContentDisposition: aws.String("attachment"),
+		ContentType:        aws.String(defaultS3ContentType),
+	}
+	if awsConnectionData.ContentType != "" {
+		input.ContentType = aws.String(awsConnectionData.ContentType)
+	}
+	if len(awsConnectionData.Metadata) > 0 {
+		input.Metadata = aws.StringMap(awsConnectionData.Metadata)
+	}
+	if len(awsConnectionData.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range awsConnectionData.Tags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if awsConnectionData.StorageClass != "" {
+		input.StorageClass = aws.String(awsConnectionData.StorageClass)
+	}
+	if awsConnectionData.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(awsConnectionData.ServerSideEncryption)
+	}
+	if awsConnectionData.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(awsConnectionData.SSEKMSKeyID)
+	}
+	if awsConnectionData.CacheControl != "" {
+		input.CacheControl = aws.String(awsConnectionData.CacheControl)
+	}
+	return input
+}
 
-	bucketExists := s3BucketExists(s3Client, bucketName)
+// s3AddDataToBucket uploads the object unless it already exists, which S3 checks atomically
+// through a conditional write rather than a separate HEAD request that could race with another upload
+func s3AddDataToBucket(s3Client *s3.S3, input *s3.PutObjectInput, checkBucket bool) error {
 
-	if bucketExists && !s3FileExists(s3Client, bucketName, objectName) {
-		_, err := s3Client.PutObject(&s3.PutObjectInput{
-			Bucket:             aws.String(bucketName),
-			Key:                aws.String(objectName),
-			ACL:                aws.String("private"),
-			Body:               bytes.NewReader(data),
-			ContentDisposition: aws.String("attachment"),
-		})
-		if err != nil {
+	bucketName := aws.StringValue(input.Bucket)
+	objectName := aws.StringValue(input.Key)
+
+	if checkBucket {
+		if err := s3BucketExists(s3Client, bucketName); err != nil {
 			return err
 		}
-	} else {
-		return fmt.Errorf("bucket %s does not exist or file %s already exists", bucketName, objectName)
 	}
 
-	return nil
-}
-
-func s3FileExists(s3Client *s3.S3, bucketName string, objectName string) bool {
-
-	_, err := s3Client.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(objectName),
+	putRequest, _ := s3Client.PutObjectRequest(input)
+	// The SDK does not model conditional writes yet, the header is signed with the rest of the request
+	putRequest.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
 	})
 
-	if err != nil {
-		log.WithFields(log.Fields{
-			"Method": "s3FileExists",
-			"Action": "checking if aws file already exists",
-			"Error":  err,
-		}).Error("Failed creating AWS client")
+	if err := putRequest.Send(); err != nil {
+		if failure, ok := err.(awserr.RequestFailure); ok &&
+			(failure.StatusCode() == http.StatusPreconditionFailed || failure.StatusCode() == http.StatusConflict) {
+			return errors.Wrapf(errObjectExists, "file %s already exists in bucket %s", objectName, bucketName)
+		}
+		return err
 	}
 
-	return err == nil
+	return nil
 }
 
-func s3BucketExists(s3Client *s3.S3, bucketName string) bool {
+// s3BucketExists checks the bucket is reachable with HeadBucket, which only needs access to the bucket itself
+// unlike listing every bucket of the account
+func s3BucketExists(s3Client *s3.S3, bucketName string) error {
 
-	s3Buckets, err := s3Client.ListBuckets(nil)
+	_, err := s3Client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	})
 	if err != nil {
-		return false
+		return errors.Wrapf(err, "bucket %s does not exist or is not accessible", bucketName)
 	}
-
-	for _, bucket := range s3Buckets.Buckets {
-		if bucketName == *bucket.Name {
-			return true
-		}
-	}
-	return false
+	return nil
 }
 
 // Remove this linter comment once the unmarshal is used in another function
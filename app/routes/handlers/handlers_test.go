@@ -13,17 +13,24 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gorilla/mux"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/cloudConnector"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/config"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/pkg/web"
+	"github.com/pkg/errors"
 )
 
 type inputTest struct {
@@ -31,11 +38,35 @@ type inputTest struct {
 	code  int
 }
 
+var testDeliveries *cloudConnector.DeliveryQueue
+var testDeadLetters *cloudConnector.DeadLetterStore
+
 func TestMain(m *testing.M) {
 
 	_ = config.InitConfig(nil)
 
-	os.Exit(m.Run())
+	dataDir, err := ioutil.TempDir("", "cloud-connector")
+	if err != nil {
+		fmt.Printf("Unable to create data directory: %s", err.Error())
+		os.Exit(1)
+	}
+	testDeadLetters, err = cloudConnector.NewDeadLetterStore(filepath.Join(dataDir, "deadletters"))
+	if err != nil {
+		fmt.Printf("Unable to create dead-letter store: %s", err.Error())
+		os.Exit(1)
+	}
+	testDeliveries, err = cloudConnector.NewDeliveryQueue(filepath.Join(dataDir, "queue"), cloudConnector.DeliveryQueueSettings{
+		RetryInterval: time.Second,
+		DeadLetters:   testDeadLetters,
+	})
+	if err != nil {
+		fmt.Printf("Unable to create delivery queue: %s", err.Error())
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dataDir)
+	os.Exit(code)
 
 }
 
@@ -80,7 +111,9 @@ func TestCallWebhook(t *testing.T) {
 
 	recorder := httptest.NewRecorder()
 
-	cloudConnector := CloudConnector{}
+	queued := testDeliveries.Len()
+
+	cloudConnector := CloudConnector{Deliveries: testDeliveries}
 
 	handler := web.Handler(cloudConnector.CallWebhook)
 
@@ -89,6 +122,78 @@ func TestCallWebhook(t *testing.T) {
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected pass with 200 but returned: %d", recorder.Code)
 	}
+
+	if testDeliveries.Len() != queued+1 {
+		t.Errorf("Expected async webhook to be queued, queue length is %d", testDeliveries.Len())
+	}
+
+	var jobResponse JobResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &jobResponse); err != nil || jobResponse.JobID == "" {
+		t.Errorf("Expected a job id in the response, received %s", recorder.Body.String())
+	}
+	if recorder.Header().Get("Location") != "/jobs/"+jobResponse.JobID {
+		t.Errorf("Expected location of the job status, received %s", recorder.Header().Get("Location"))
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	queueDir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(queueDir)
+
+	jobs := cloudConnector.NewJobTracker(10)
+	deliveries, err := cloudConnector.NewDeliveryQueue(queueDir, cloudConnector.DeliveryQueueSettings{
+		Jobs: jobs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliveries.Enqueue("queued-job", cloudConnector.Webhook{URL: "http://localhost/test", Method: "POST"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDeadLetters.Add(cloudConnector.DeadLetter{ID: "failed-job", LastStatusCode: http.StatusBadRequest}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = testDeadLetters.Remove("failed-job") }()
+
+	connector := CloudConnector{Deliveries: deliveries, DeadLetters: testDeadLetters, Jobs: jobs}
+	handler := web.Handler(connector.GetJob)
+
+	var testCases = []struct {
+		id     string
+		code   int
+		status string
+	}{
+		{"queued-job", http.StatusOK, cloudConnector.JobQueued},
+		{"failed-job", http.StatusOK, cloudConnector.JobFailed},
+		{"unknown-job", http.StatusNotFound, ""},
+	}
+	for _, testCase := range testCases {
+		request, err := http.NewRequest("GET", "/jobs/"+testCase.id, nil)
+		if err != nil {
+			t.Fatalf("Unable to create new HTTP request %s", err.Error())
+		}
+		request = mux.SetURLVars(request, map[string]string{"id": testCase.id})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != testCase.code {
+			t.Errorf("Expected %d for job %s, received %d", testCase.code, testCase.id, recorder.Code)
+			continue
+		}
+		if testCase.status == "" {
+			continue
+		}
+		var job cloudConnector.Job
+		if err := json.Unmarshal(recorder.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != testCase.status {
+			t.Errorf("Expected job %s to be %s, received %s", testCase.id, testCase.status, job.Status)
+		}
+	}
 }
 
 func TestCallWebhookwithGetRequest(t *testing.T) {
@@ -183,8 +288,71 @@ func TestCallWebhookNotAsync(t *testing.T) {
 
 	handler.ServeHTTP(recorder, request)
 
-	if recorder.Code != http.StatusNotFound {
-		t.Errorf("Expected to fail with 404 but returned: %d", recorder.Code)
+	// Nothing listens on the auth endpoint
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected to fail with 502 but returned: %d", recorder.Code)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCallWebhookRetriesExhausted(t *testing.T) {
+	var calls int32
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if request.URL.EscapedPath() == "/unavailable" {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testMockServer.Close()
+
+	webhooks := []struct {
+		path     string
+		code     int
+		attempts int32
+	}{
+		{"/unavailable", http.StatusBadGateway, 2},
+		{"/missing", http.StatusNotFound, 1},
+	}
+	for _, item := range webhooks {
+		atomic.StoreInt32(&calls, 0)
+		data := cloudConnector.Webhook{
+			URL:    testMockServer.URL + item.path,
+			Method: "POST",
+			Retry: &cloudConnector.RetryPolicy{
+				MaxAttempts:          2,
+				InitialBackoff:       1,
+				RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+				RetryableErrors:      []string{cloudConnector.TimeoutError},
+			},
+		}
+		mData, marshalErr := json.Marshal(data)
+		if marshalErr != nil {
+			t.Fatalf("Unable to marshal data: %s", marshalErr.Error())
+		}
+		request, err := http.NewRequest("POST", "/callwebhook", bytes.NewBuffer(mData))
+		if err != nil {
+			t.Fatalf("Unable to create new HTTP Request: %s", err.Error())
+		}
+
+		recorder := httptest.NewRecorder()
+		connector := CloudConnector{}
+		web.Handler(connector.CallWebhook).ServeHTTP(recorder, request)
+
+		if recorder.Code != item.code || atomic.LoadInt32(&calls) != item.attempts {
+			t.Errorf("Expected %s to fail with %d after %d attempts, returned %d after %d", item.path, item.code, item.attempts, recorder.Code, calls)
+		}
+	}
+
+	timeout := &url.Error{Op: "Post", URL: testMockServer.URL, Err: timeoutError{}}
+	if code := webhookFailureStatus(nil, errors.Wrap(timeout, "unable to POST endpoint")); code != http.StatusGatewayTimeout {
+		t.Errorf("Expected a timeout to fail with 504, returned %d", code)
 	}
 }
 
@@ -192,7 +360,7 @@ func TestCallWebhookWithForbiddenHTTPMethods(t *testing.T) {
 
 	data := cloudConnector.Webhook{
 		URL:    "http://localhost/test",
-		Method: "PUT",
+		Method: "TRACE",
 		Auth: cloudConnector.Auth{
 			AuthType: "oauth2",
 			Endpoint: "http://localhost/testServerURL/oauth",
@@ -321,6 +489,117 @@ func TestCallWebhookSchemaFailed(t *testing.T) {
 				}`),
 			code: 400,
 		},
+		{
+			// hmac auth without a secret
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "hmac", "algorithm": "sha256"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// unknown hmac algorithm
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "hmac", "secret": "shared", "algorithm": "md5"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// aws-sigv4 auth without a region
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "aws-sigv4", "service": "execute-api", "accesskeyid": "id", "secretaccesskey": "key"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// basic auth without a username
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "basic", "password": "secret"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// bearer auth without a token
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "bearer", "token": ""},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// apikey-query auth without an apikey
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "apikey-query", "paramname": "code"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// apikey-query auth with an invalid parameter name
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"authtype": "apikey-query", "apikey": "key", "paramname": "a&b"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// unknown payload encoding
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"encoding": "yaml",
+				"payload": "sku: 1"
+				}`),
+			code: 400,
+		},
+		{
+			// xml encoding needs the document as a string
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"encoding": "xml",
+				"payload": {"sku": 1}
+				}`),
+			code: 400,
+		},
+		{
+			// invalid content type
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"encoding": "text",
+				"contenttype": "not a content type",
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// client certificate without its key
+			input: []byte(`{
+				"url": "http://local/test",
+				"method": "POST",
+				"auth": {"clientcert": "-----BEGIN CERTIFICATE-----"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
 		{
 			// Empty request body
 			input: []byte(`{}`),
@@ -335,6 +614,101 @@ func TestCallWebhookSchemaFailed(t *testing.T) {
 	testHandlerHelper(invalidJSONSample, handler, t)
 }
 
+func TestCallWebhookCredentialRef(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, []byte(`{"erp-hmac": {"secret": "shared"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := cloudConnector.NewCredentialStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloudConnector.SetCredentialStore(store)
+	defer cloudConnector.SetCredentialStore(nil)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Signature") == "" {
+			writer.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer testServer.Close()
+
+	var webhookSample = []inputTest{
+		{
+			// hmac secret coming from the credential store
+			input: []byte(`{
+				"url": "` + testServer.URL + `",
+				"method": "POST",
+				"auth": {"authtype": "hmac", "credentialref": "erp-hmac"},
+				"payload": "sku"
+				}`),
+			code: 200,
+		},
+		{
+			// webhook level reference
+			input: []byte(`{
+				"url": "` + testServer.URL + `",
+				"method": "POST",
+				"credentialref": "erp-hmac",
+				"auth": {"authtype": "hmac"},
+				"payload": "sku"
+				}`),
+			code: 200,
+		},
+		{
+			// unknown credential
+			input: []byte(`{
+				"url": "` + testServer.URL + `",
+				"method": "POST",
+				"auth": {"authtype": "hmac", "credentialref": "missing"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// inline secrets are disabled
+			input: []byte(`{
+				"url": "` + testServer.URL + `",
+				"method": "POST",
+				"auth": {"authtype": "hmac", "secret": "inline"},
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+		{
+			// invalid credential name
+			input: []byte(`{
+				"url": "` + testServer.URL + `",
+				"method": "POST",
+				"credentialref": "../erp-hmac",
+				"payload": "sku"
+				}`),
+			code: 400,
+		},
+	}
+
+	cloudConnector := CloudConnector{}
+
+	handler := web.Handler(cloudConnector.CallWebhook)
+
+	testHandlerHelper(webhookSample, handler, t)
+
+	awsHandler := web.Handler(cloudConnector.AwsCloud)
+
+	testHandlerHelper([]inputTest{
+		{
+			// unknown credential
+			input: []byte(`{"credentialref": "missing", "bucket": "bucket", "payload": "data"}`),
+			code:  400,
+		},
+	}, awsHandler, t)
+}
+
 func TestAwsCloudCallInvalidJsonInput(t *testing.T) {
 
 	var invalidJSONSample = []inputTest{
@@ -361,6 +735,82 @@ func TestAwsCloudCallInvalidJsonInput(t *testing.T) {
 			}`),
 			code: 400,
 		},
+		{
+			// key template field missing from the payload
+			input: []byte(`{
+				"accesskeyid": "keyid",
+				"secretaccesskey": "key",
+				"bucket": "bucket",
+				"region" : "us-west-2",
+				"keytemplate": "stores/{storeId}/{traceId}.json",
+				"payload" : {"sku": "1"}
+			}`),
+			code: 400,
+		},
+		{
+			// session token without its keys
+			input: []byte(`{
+				"sessiontoken": "session",
+				"bucket": "bucket",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// external ID without a role
+			input: []byte(`{
+				"bucket": "bucket",
+				"externalid": "store-105",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// invalid role ARN
+			input: []byte(`{
+				"bucket": "bucket",
+				"rolearn": "uploader",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// KMS key without KMS encryption
+			input: []byte(`{
+				"bucket": "bucket",
+				"serversideencryption": "AES256",
+				"ssekmskeyid": "alias/uploads",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// unknown storage class
+			input: []byte(`{
+				"bucket": "bucket",
+				"storageclass": "COLD",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// too many tags
+			input: []byte(`{
+				"bucket": "bucket",
+				"tags": {"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6", "g": "7", "h": "8", "i": "9", "j": "10", "k": "11"},
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
+		{
+			// metadata that can not be sent as a header
+			input: []byte(`{
+				"bucket": "bucket",
+				"metadata": {"store id": "105"},
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
 	}
 
 	cloudConnector := CloudConnector{}
@@ -391,33 +841,209 @@ func testHandlerHelper(input []inputTest, handler web.Handler, t *testing.T) {
 	}
 }
 
-func TestS3FileDoesntExist(t *testing.T) {
-	region := "us-west-2"
-	var logLevel aws.LogLevelType = 1
+// fakeS3 is an S3 compatible store holding a single bucket, which answers the conditional writes the same way S3 does
+type fakeS3 struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	objects map[string]string
+	headers map[string]http.Header
+}
 
+func newFakeS3(bucket string) *fakeS3 {
+	store := &fakeS3{objects: map[string]string{}, headers: map[string]http.Header{}}
+	store.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		path := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 2)
+		if path[0] != bucket {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if request.Method == http.MethodHead && len(path) == 1 {
+			return
+		}
+		if request.Method != http.MethodPut || len(path) != 2 || request.Header.Get("If-None-Match") != "*" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(request.Body)
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		if _, ok := store.objects[path[1]]; ok {
+			writer.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = writer.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+			return
+		}
+		store.objects[path[1]] = string(body)
+		store.headers[path[1]] = request.Header
+	}))
+	return store
+}
+
+func (store *fakeS3) object(key string) string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.objects[key]
+}
+
+func (store *fakeS3) header(key string) http.Header {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.headers[key]
+}
+
+func (store *fakeS3) client(t *testing.T) *s3.S3 {
 	awsConfig := aws.Config{
-		Region:      &region,
-		Credentials: credentials.NewStaticCredentials("AccessKeyID", "SecretAccessKey", ""),
-		LogLevel:    &logLevel,
+		Region:           aws.String("us-west-2"),
+		Credentials:      credentials.NewStaticCredentials("AccessKeyID", "SecretAccessKey", ""),
+		Endpoint:         aws.String(store.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
 	}
-
 	sess, err := session.NewSessionWithOptions(session.Options{
 		Config: awsConfig,
 	})
-
 	if err != nil {
-		t.Errorf("Failed to create the session %v", err)
+		t.Fatalf("Failed to create the session %v", err)
+	}
+	return s3.New(sess)
+}
+
+func TestS3AddDataToBucket(t *testing.T) {
+	store := newFakeS3("bucket")
+	defer store.Close()
+	s3Client := store.client(t)
+	upload := cloudConnector.AwsConnectionData{Bucket: "bucket"}
+
+	if err := s3AddDataToBucket(s3Client, newPutObjectInput(upload, "stores/105/file.json", []byte(`"data"`)), true); err != nil {
+		t.Fatalf("Expected the upload to succeed, received %v", err)
+	}
+	if store.object("stores/105/file.json") != `"data"` {
+		t.Errorf("Expected the object to be uploaded, received %v", store.objects)
+	}
+	if header := store.header("stores/105/file.json"); header.Get("Content-Type") != "application/json" || header.Get("X-Amz-Acl") != "private" {
+		t.Errorf("Expected a private json object by default, received %v", header)
+	}
+
+	err := s3AddDataToBucket(s3Client, newPutObjectInput(upload, "stores/105/file.json", []byte(`"other"`)), false)
+	if errors.Cause(err) != errObjectExists {
+		t.Errorf("Expected an existing object to be reported, received %v", err)
+	}
+	if store.object("stores/105/file.json") != `"data"` {
+		t.Error("Expected an existing object to be left untouched")
+	}
+
+	if err := s3AddDataToBucket(s3Client, newPutObjectInput(cloudConnector.AwsConnectionData{Bucket: "missing"}, "file.json", []byte(`"data"`)), true); err == nil || errors.Cause(err) == errObjectExists {
+		t.Errorf("Expected a missing bucket to be reported, received %v", err)
+	}
+}
+
+func TestS3UploadOptions(t *testing.T) {
+	store := newFakeS3("bucket")
+	defer store.Close()
+
+	upload := cloudConnector.AwsConnectionData{
+		Bucket:               "bucket",
+		ContentType:          "text/csv; charset=utf-8",
+		Metadata:             map[string]string{"store-id": "105"},
+		Tags:                 map[string]string{"retention": "90d", "source": "rfid sensor"},
+		StorageClass:         "STANDARD_IA",
+		ServerSideEncryption: "aws:kms",
+		SSEKMSKeyID:          "arn:aws:kms:us-west-2:123456789012:key/1234abcd",
+		CacheControl:         "no-cache",
+	}
+	if err := s3AddDataToBucket(store.client(t), newPutObjectInput(upload, "file.csv", []byte("sku")), false); err != nil {
+		t.Fatalf("Expected the upload to succeed, received %v", err)
+	}
+
+	expected := map[string]string{
+		"Content-Type":                                "text/csv; charset=utf-8",
+		"X-Amz-Meta-Store-Id":                         "105",
+		"X-Amz-Tagging":                               "retention=90d&source=rfid+sensor",
+		"X-Amz-Storage-Class":                         "STANDARD_IA",
+		"X-Amz-Server-Side-Encryption":                "aws:kms",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "arn:aws:kms:us-west-2:123456789012:key/1234abcd",
+		"Cache-Control":                               "no-cache",
+		"Content-Disposition":                         "attachment",
+	}
+	header := store.header("file.csv")
+	for name, value := range expected {
+		if header.Get(name) != value {
+			t.Errorf("Expected %s to be %s, received %s", name, value, header.Get(name))
+		}
+	}
+}
+
+func TestAwsCloudCallS3CompatibleEndpoint(t *testing.T) {
+	// The handler variable shadows the package
+	setAwsDefaultCredentials := cloudConnector.SetAwsDefaultCredentials
+	store := newFakeS3("bucket")
+	defer store.Close()
+	endpoint := strings.TrimPrefix(store.URL, "http://")
+
+	var samples = []inputTest{
+		{
+			// path style upload to a local store, the region is optional
+			input: []byte(`{
+				"accesskeyid": "keyid",
+				"secretaccesskey": "key",
+				"sessiontoken": "session",
+				"bucket": "bucket",
+				"endpoint": "` + endpoint + `",
+				"forcepathstyle": true,
+				"disablessl": true,
+				"keytemplate": "stores/{storeId}.json",
+				"payload" : {"storeId": "105"}
+			}`),
+			code: 200,
+		},
+		{
+			// the object already exists
+			input: []byte(`{
+				"accesskeyid": "keyid",
+				"secretaccesskey": "key",
+				"bucket": "bucket",
+				"endpoint": "` + store.URL + `",
+				"forcepathstyle": true,
+				"keytemplate": "stores/{storeId}.json",
+				"payload" : {"storeId": "105"}
+			}`),
+			code: 409,
+		},
+		{
+			// invalid endpoint settings
+			input: []byte(`{
+				"accesskeyid": "keyid",
+				"secretaccesskey": "key",
+				"bucket": "bucket",
+				"endpoint": "` + store.URL + `",
+				"forcepathstyle": "yes",
+				"payload" : "data"
+			}`),
+			code: 400,
+		},
 	}
+	cloudConnector := CloudConnector{}
+	handler := web.Handler(cloudConnector.AwsCloud)
+	testHandlerHelper(samples, handler, t)
 
-	newClient := s3.New(sess, &awsConfig)
-	exists := s3FileExists(newClient, "bucket", "file")
+	// Without keys the upload needs the credentials of the service, which are disabled by default
+	setAwsDefaultCredentials(false)
+	testHandlerHelper([]inputTest{
+		{
+			input: []byte(`{"bucket": "bucket", "endpoint": "` + store.URL + `", "payload" : "data"}`),
+			code:  400,
+		},
+	}, handler, t)
 
-	if exists {
-		t.Error("File was found when it should not exist")
+	if store.object("stores/105.json") != `{"storeId":"105"}` {
+		t.Errorf("Expected the payload to be uploaded to the S3 compatible endpoint, received %v", store.objects)
 	}
 }
 
 func TestAwsCloudCallValidJsonInputWithFailure(t *testing.T) {
+	// The store does not hold the bucket
+	store := newFakeS3("other-bucket")
+	defer store.Close()
+
 	var validJSONSample = []inputTest{
 		{
 			// extra characters in json
@@ -426,6 +1052,8 @@ func TestAwsCloudCallValidJsonInputWithFailure(t *testing.T) {
 				"secretaccesskey": "key",
 				"bucket": "bucket",
 				"region" : "us-west-2",
+				"endpoint": "` + store.URL + `",
+				"forcepathstyle": true,
 				"payload" : "data"
 			}`),
 			code: 400,
@@ -435,3 +1063,166 @@ func TestAwsCloudCallValidJsonInputWithFailure(t *testing.T) {
 	handler := web.Handler(cloudConnector.AwsCloud)
 	testHandlerHelper(validJSONSample, handler, t)
 }
+
+func TestDeadLetterEndpoints(t *testing.T) {
+	if _, err := testDeadLetters.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	deadLetter := cloudConnector.DeadLetter{
+		ID: "trace-failed",
+		Webhook: cloudConnector.Webhook{
+			URL:     "http://localhost/test",
+			Method:  "POST",
+			IsAsync: true,
+			Auth: cloudConnector.Auth{
+				AuthType:        "oauth2",
+				Data:            "secret-data",
+				ClientSecret:    "secret-client",
+				Password:        "secret-password",
+				PrivateKey:      "secret-private-key",
+				SecretAccessKey: "secret-aws-key",
+			},
+			Header:   http.Header{"Authorization": {"secret-authorization"}, "X-Api-Key": {"secret-api-key"}, "Cookie": {"secret-cookie"}},
+			Callback: &cloudConnector.Callback{URL: "http://localhost/done", Header: http.Header{"X-Callback-Token": {"secret-callback"}}},
+		},
+		LastStatusCode: http.StatusBadRequest,
+	}
+	assertRedacted := func(body string) {
+		if strings.Contains(body, "secret-") {
+			t.Errorf("Expected the secrets of the dead letter to be redacted, received %s", body)
+		}
+	}
+	if err := testDeadLetters.Add(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+
+	connector := CloudConnector{Deliveries: testDeliveries, DeadLetters: testDeadLetters}
+	serve := func(handler web.Handler, method string, id string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, "/deadletters", nil)
+		if err != nil {
+			t.Fatalf("Unable to create new HTTP request %s", err.Error())
+		}
+		if id != "" {
+			request = mux.SetURLVars(request, map[string]string{"id": id})
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(connector.ListDeadLetters, "GET", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected list to return 200, returned %d", recorder.Code)
+	}
+	var list struct {
+		Results []cloudConnector.DeadLetter `json:"results"`
+		Count   int                         `json:"count"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Count != 1 || list.Results[0].ID != "trace-failed" {
+		t.Errorf("Expected the dead letter to be listed, received %s", recorder.Body.String())
+	}
+	assertRedacted(recorder.Body.String())
+
+	recorder = serve(connector.GetDeadLetter, "GET", "trace-failed")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected get to return 200, returned %d", recorder.Code)
+	}
+	assertRedacted(recorder.Body.String())
+	if recorder := serve(connector.GetDeadLetter, "GET", "unknown"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected get of unknown id to return 404, returned %d", recorder.Code)
+	}
+
+	queued := testDeliveries.Len()
+	if recorder := serve(connector.ReplayDeadLetter, "POST", "trace-failed"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected replay to return 200, returned %d", recorder.Code)
+	}
+	if testDeliveries.Len() != queued+1 {
+		t.Errorf("Expected replayed dead letter to be queued")
+	}
+	if _, err := testDeadLetters.Get("trace-failed"); err != cloudConnector.ErrDeadLetterNotFound {
+		t.Errorf("Expected replayed dead letter to be removed")
+	}
+
+	if err := testDeadLetters.Add(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(connector.DeleteDeadLetter, "DELETE", "trace-failed"); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected delete to return 204, returned %d", recorder.Code)
+	}
+	if recorder := serve(connector.DeleteDeadLetter, "DELETE", "trace-failed"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected second delete to return 404, returned %d", recorder.Code)
+	}
+
+	if err := testDeadLetters.Add(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(connector.PurgeDeadLetters, "DELETE", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected purge to return 200, returned %d", recorder.Code)
+	}
+	if deadLetters, _ := testDeadLetters.List(); len(deadLetters) != 0 {
+		t.Errorf("Expected purge to remove every dead letter, %d left", len(deadLetters))
+	}
+}
+
+func TestReplayDeadLetterFailsAgain(t *testing.T) {
+	testMockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadRequest)
+	}))
+	defer testMockServer.Close()
+
+	dataDir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	deadLetters, err := cloudConnector.NewDeadLetterStore(filepath.Join(dataDir, "deadletters"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := cloudConnector.NewDeliveryQueue(filepath.Join(dataDir, "queue"), cloudConnector.DeliveryQueueSettings{
+		RetryInterval: time.Second,
+		DeadLetters:   deadLetters,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries.Start()
+	defer deliveries.Stop()
+
+	deadLetter := cloudConnector.DeadLetter{
+		ID:             "trace-failed",
+		Webhook:        cloudConnector.Webhook{URL: testMockServer.URL, Method: "POST", IsAsync: true},
+		LastStatusCode: http.StatusBadGateway,
+		FailedAt:       1,
+	}
+	if err := deadLetters.Add(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+
+	connector := CloudConnector{Deliveries: deliveries, DeadLetters: deadLetters}
+	request, err := http.NewRequest("POST", "/deadletters/trace-failed/replay", nil)
+	if err != nil {
+		t.Fatalf("Unable to create new HTTP request %s", err.Error())
+	}
+	recorder := httptest.NewRecorder()
+	web.Handler(connector.ReplayDeadLetter).ServeHTTP(recorder, mux.SetURLVars(request, map[string]string{"id": "trace-failed"}))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected replay to return 200, returned %d", recorder.Code)
+	}
+
+	// The replay is rejected for good, so it must come back as a new dead letter
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		replayed, err := deadLetters.Get("trace-failed")
+		if err == nil && replayed.LastStatusCode == http.StatusBadRequest && replayed.FailedAt != deadLetter.FailedAt {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the failed replay to be stored as a dead letter, found %+v %v", replayed, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -22,10 +22,8 @@ type Route struct {
 	HandlerFunc web.Handler
 }
 
-// NewRouter creates the routes for GET and POST
-func NewRouter() *mux.Router {
-
-	cloudConnector := handlers.CloudConnector{}
+// NewRouter creates the routes for the handler set
+func NewRouter(connector *handlers.CloudConnector) *mux.Router {
 
 	var routes = []Route{
 		// swagger:operation GET / default Healthcheck
@@ -52,7 +50,7 @@ func NewRouter() *mux.Router {
 			"Index",
 			"GET",
 			"/",
-			cloudConnector.Index,
+			connector.Index,
 		},
 		// swagger:operation POST /callwebhook webhooks callwebhook
 		//
@@ -62,32 +60,127 @@ func NewRouter() *mux.Router {
 		//
 		//     URL - (required) The call back URL. Responsive Retail must be able to post data to this URL.
 		//
-		//	   Method - (required) The http method to be ran on the webhook(Allowed methods: GET or POST)
+		//	   Method - (required) The http method to be ran on the webhook(Allowed methods: GET, POST, PUT, PATCH, DELETE, HEAD or OPTIONS). POST, PUT and PATCH send the payload as the request body, DELETE only when a payload is given
+		//
+		//	   Header - (optional) The header for the webhook. It is merged with the content type of the encoding, which it can replace,
+		//	   and with the headers derived from Auth, which win unless listed in OverrideHeaders. Hop-by-hop headers are not forwarded.
 		//
-		//	   Header - (optional) The header for the webhook
+		//	   OverrideHeaders - (optional) Header names for which the caller value replaces the Auth derived value (ex. Authorization)
 		//
-		//	   IsAsync - (required) Whether the cloud call should be made sync or async. To be notified of errors connecting to the cloud use IsAsync:true.GET HTTP verb ignores IsAsync flag.
+		//	   IsAsync - (required) Whether the cloud call should be made sync or async. To be notified of errors connecting to the cloud use IsAsync:true.GET, HEAD and OPTIONS HTTP verbs ignore IsAsync flag.
+		//	   Async calls are stored in a persistent queue and delivered in order once the cloud endpoint is reachable, surviving service restarts.
+		//	   The response of an async call contains a jobid which can be used with /jobs/{id} to follow the delivery.
 		//
 		//     Auth - (optional) Authentication settings used
-		//       - AuthType - The Authentication method defined by the webhook (ex. OAuth2)
+		//       - AuthType - The Authentication method defined by the webhook (OAuth2, JWT-Bearer, Service-Account, HMAC, AWS-SigV4, Basic, Bearer, APIKey-Header or APIKey-Query)
 		//       - Endpoint - The Authentication endpoint if it differs from the webhook server
 		//       - Data - The Authentication data required by the authentication server
+		//       - ClientID - (optional) OAuth2 client ID. When set, the token is requested with the client credentials grant (RFC 6749) instead of sending Data
+		//       - ClientSecret - OAuth2 client secret
+		//       - Scope - (optional) Space separated scopes requested for the token
+		//       - Audience - (optional) Audience requested for the token
+		//       - ClientAuthMethod - (optional) How the client credentials are sent: client_secret_basic (default) or client_secret_post
+		//       - RefreshToken - (optional) OAuth2 refresh token exchanged for access tokens (RFC 6749 refresh token grant). Refresh tokens rotated by the authorization server are kept by the service, the configured one can still be sent
+		//       - Secret - HMAC shared secret. HMAC signs the encoded payload into a t=<unix seconds>,v1=<hex signature> header where the signature covers "<t>.<payload>"
+		//       - Algorithm - (optional) HMAC algorithm: sha256 (default) or sha512
+		//       - HeaderName - (optional) Header carrying the HMAC signature, defaults to X-Signature
+		//       - Region, Service - AWS region and service name (ex. execute-api) the request is signed for with AWS-SigV4. The signature covers the caller headers
+		//       - AccessKeyID, SecretAccessKey - AWS credentials signing the request
+		//       - SessionToken - (optional) AWS session token of temporary credentials
+		//       - Username, Password - Basic credentials
+		//       - Token - Bearer token
+		//       - APIKey - API key sent in the HeaderName header (defaults to X-API-Key) or the ParamName query parameter (defaults to api_key)
+		//       - ParamName - (optional) Query parameter carrying the API key
+		//       - PrivateKey - RSA or P-256 ECDSA private key in PEM format signing the JWT-Bearer assertion (RFC 7523), exchanged at Endpoint for an access token
+		//       - KeyID - (optional) Key ID set in the assertion header
+		//       - Issuer, Subject - Issuer and subject of the assertion, the subject defaults to the issuer. Audience defaults to Endpoint
+		//       - ServiceAccount - Google service account JSON key providing the issuer, private key and token endpoint of Service-Account auth. Scope is requested in the assertion and Subject impersonates a user
+		//       - ClientCert, ClientKey - (optional) PEM client certificate and key presented to the webhook and token endpoints requiring mutual TLS, with any AuthType
+		//       - CredentialRef - (optional) Name of a credential of the service credential store whose settings replace the ones above, so secrets are not sent with the request
+		//
+		//     CredentialRef - (optional) Name of a stored credential used as Auth settings when Auth does not reference one. When the service disallows inline secrets, secrets must come from a stored credential
 		//
 		//     Payload - (optional) The payload intended for the destination webhook. This is typically a json object or map of values.
 		//
+		//     Encoding - (optional) How the payload is encoded in the request body
+		//       - json - (default) The payload is sent as json
+		//       - form - An object of strings, numbers, booleans or arrays of those sent url encoded. A string payload is sent as is
+		//       - xml - The payload is a string holding the XML document
+		//       - text - The payload is a string sent as plain text
+		//       - base64 - The payload is a base64 string decoded into a binary body
+		//
+		//     ContentType - (optional) Replaces the default content type of the encoding (ex. text/csv)
+		//
+		//     Retry - (optional) Retry policy for failed calls, unset values default to the service configuration
+		//       - MaxAttempts - Number of attempts including the first one
+		//       - InitialBackoff - Milliseconds to wait before the first retry, doubled on every further retry
+		//       - MaxBackoff - Upper bound in milliseconds for the wait between retries. A Retry-After header above this bound stops the retries
+		//       - Jitter - Fraction (0 to 1) of random variation applied to every backoff
+		//       - RetryableStatusCodes - Response status codes that are retried (ex. 502, 503, 504)
+		//       - RetryableErrors - Network errors that are retried: timeout, connectionrefused, connectionreset, dns
+		//
+		//     Callback - (optional) Local endpoint notified once an async call succeeded or failed for good
+		//       - URL - The callback URL, the outcome is posted to it as json with the traceid, status, attempts and the last statuscode, header and body
+		//       - Header - (optional) The header sent to the callback
+		//       - MaxBodySize - (optional) Bytes of the cloud response body forwarded to the callback, defaults to 4096
+		//
 		//     Expected formatting of JSON input (as an example):<br><br>
 		//
 		//```
 		// {
 		// 	"url": "string",
 		//	"method": "string",
+		// 	"header": {"string": ["string"]},
+		// 	"overrideheaders": ["string"],
 		// 	"auth": {
 		// 	  "authtype": "string",
 		// 		"endpoint": "string",
-		// 		"data":     "string"
+		// 		"data":     "string",
+		// 		"clientid": "string",
+		// 		"clientsecret": "string",
+		// 		"scope": "string",
+		// 		"audience": "string",
+		// 		"clientauthmethod": "client_secret_basic",
+		// 		"refreshtoken": "string",
+		// 		"secret": "string",
+		// 		"algorithm": "sha256",
+		// 		"headername": "string",
+		// 		"region": "string",
+		// 		"service": "string",
+		// 		"accesskeyid": "string",
+		// 		"secretaccesskey": "string",
+		// 		"sessiontoken": "string",
+		// 		"username": "string",
+		// 		"password": "string",
+		// 		"token": "string",
+		// 		"apikey": "string",
+		// 		"paramname": "string",
+		// 		"privatekey": "string",
+		// 		"keyid": "string",
+		// 		"issuer": "string",
+		// 		"subject": "string",
+		// 		"serviceaccount": "string",
+		// 		"clientcert": "string",
+		// 		"clientkey": "string",
+		// 		"credentialref": "string"
 		// 	},
+		// 	"credentialref": "string",
 		// 	"isasync": 		boolean,
-		// 	"payload": "interface"
+		// 	"payload": "interface",
+		// 	"encoding": "json",
+		// 	"contenttype": "string",
+		// 	"retry": {
+		// 		"maxattempts": 5,
+		// 		"initialbackoff": 500,
+		// 		"maxbackoff": 30000,
+		// 		"jitter": 0.2,
+		// 		"retryablestatuscodes": [502, 503, 504],
+		// 		"retryableerrors": ["timeout", "connectionrefused"]
+		// 	},
+		// 	"callback": {
+		// 		"url": "string",
+		// 		"maxbodysize": 4096
+		// 	}
 		//  }
 		//  ```
 		// ---
@@ -113,12 +206,16 @@ func NewRouter() *mux.Router {
 		//      description: Not Found
 		//   '500':
 		//      description: Internal server error
+		//   '502':
+		//      description: The destination could not be reached or kept answering with a server error once the retries were exhausted
+		//   '504':
+		//      description: The destination kept timing out once the retries were exhausted
 		//
 		{
 			"CallWebhook",
 			"POST",
 			"/callwebhook",
-			cloudConnector.CallWebhook,
+			connector.CallWebhook,
 		},
 		// swagger:operation POST /aws-cloud/data awsclouddata AwsCloud
 		//
@@ -126,14 +223,46 @@ func NewRouter() *mux.Router {
 		//
 		// This API call is used to upload data to an S3 bucket by passing the access key id, secret access key, region, and bucket name in the request along with the payload.
 		//
-		//     AccessKeyID - (required) AWS access key ID
+		//     AccessKeyID - (optional) AWS access key ID. Without keys nor CredentialRef the upload is rejected, unless awsDefaultCredentials lets it use the credentials of the service: environment variables, shared configuration files, then the ECS task or EC2 instance role
+		//
+		//     SecretAccessKey - (required with AccessKeyID) AWS secret access key
+		//
+		//     SessionToken - (optional) Session token of temporary credentials, with AccessKeyID
 		//
-		//     SecretAccessKey - (required) AWS secret access key
+		//     RoleARN - (optional) ARN of a role assumed through STS with the credentials above, the upload uses the role credentials
 		//
-		//     Region - (required) AWS Region
+		//     ExternalID - (optional) External ID required by the trust policy of the role
+		//
+		//     RoleSessionName - (optional) Session name of the assumed role, shown in CloudTrail. Defaults to cloud-connector
+		//
+		//     Region - (required) AWS Region, it can also come from the stored credential. Defaults to us-east-1 with an Endpoint
+		//
+		//     CredentialRef - (optional) Name of a credential of the service credential store providing the access keys, session token and region
 		//
 		//	   Bucket - (required) The bucket path/name
 		//
+		//     KeyTemplate - (optional) Object key with placeholders, ex. stores/{storeId}/dt={yyyy}-{MM}-{dd}/{traceId}.json for Hive style partitions. {yyyy} {MM} {dd} {HH} {mm} {ss} {unixmillis} are the upload time in UTC, {traceId} the trace ID of the request, {uuid} a random UUID, and any other name a string, number or boolean field of the payload, nested fields separated by dots. A template ending with / is a prefix and the object is named {traceId}.json. Defaults to awsfile_{unixmillis}_{uuid}.
+		//
+		//     Endpoint - (optional) URL or host:port of an S3 compatible object store such as MinIO or Ceph, replacing the AWS endpoint
+		//
+		//     ForcePathStyle - (optional) Address the bucket in the URL path (http://host/bucket/key) instead of the host name, as most S3 compatible stores require
+		//
+		//     DisableSSL - (optional) Use http instead of https when Endpoint has no scheme
+		//
+		//     ContentType - (optional) Content type of the object, defaults to application/json
+		//
+		//     Metadata - (optional) User metadata of the object, sent as x-amz-meta-* headers. Names are letters, digits, - and _, values printable ASCII
+		//
+		//     Tags - (optional) Up to 10 object tags, ex. for lifecycle rules
+		//
+		//     StorageClass - (optional) STANDARD (default), REDUCED_REDUNDANCY, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING, GLACIER or DEEP_ARCHIVE
+		//
+		//     ServerSideEncryption - (optional) AES256 for SSE-S3 or aws:kms for SSE-KMS
+		//
+		//     SSEKMSKeyID - (optional) ID, ARN or alias of the KMS key encrypting the object, requires ServerSideEncryption aws:kms. Defaults to the AWS managed key
+		//
+		//     CacheControl - (optional) Cache-Control of the object
+		//
 		//     Payload - (optional) The payload intended for the destination. This is typically a json object or map of values.
 		//
 		//     Expected formatting of JSON input (as an example):<br><br>
@@ -144,7 +273,8 @@ func NewRouter() *mux.Router {
 		//	"secretaccesskey": "<SECRET ACCESS KEY>",
 		//	"bucket": "<BUCKET>",
 		//	"region" : "<REGION>",
-		//	"payload" : "data"
+		//	"keytemplate" : "stores/{storeId}/dt={yyyy}-{MM}-{dd}/{traceId}.json",
+		//	"payload" : {"storeId": "105"}
 		//}
 		//  ```
 		// ---
@@ -166,6 +296,8 @@ func NewRouter() *mux.Router {
 		//        type: array
 		//        items:
 		//         "$ref": "#/definitions/ErrReport"
+		//   '409':
+		//      description: An object with the same key already exists in the bucket
 		//   '500':
 		//      description: Internal server error
 		//
@@ -173,7 +305,191 @@ func NewRouter() *mux.Router {
 			"AwsCloud",
 			"POST",
 			"/aws-cloud/data",
-			cloudConnector.AwsCloud,
+			connector.AwsCloud,
+		},
+		// swagger:operation GET /jobs/{id} jobs GetJob
+		//
+		// Async delivery status
+		//
+		// Returns the status of the async webhook call with the given job id, as returned by /callwebhook. The status is one of queued, in-flight, succeeded or failed.
+		// Attempts is the number of calls made so far and statuscode is the status of the last response from the destination.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: id
+		//   in: path
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//      description: OK
+		//   '404':
+		//      description: Not Found
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"GetJob",
+			"GET",
+			"/jobs/{id}",
+			connector.GetJob,
+		},
+		// swagger:operation GET /deadletters deadletters ListDeadLetters
+		//
+		// List failed deliveries
+		//
+		// Returns every async webhook delivery that could not be completed, either because the destination rejected it or because it ran out of attempts. Each entry contains the original webhook request with its auth secrets and credential headers redacted, the attempt history, the last status code and the last response body.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//      description: OK
+		//      schema:
+		//        "$ref": "#/definitions/resultsResponse"
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"ListDeadLetters",
+			"GET",
+			"/deadletters",
+			connector.ListDeadLetters,
+		},
+		// swagger:operation DELETE /deadletters deadletters PurgeDeadLetters
+		//
+		// Purge failed deliveries
+		//
+		// Removes every failed delivery. The number of removed entries is returned in count.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//      description: OK
+		//      schema:
+		//        "$ref": "#/definitions/resultsResponse"
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"PurgeDeadLetters",
+			"DELETE",
+			"/deadletters",
+			connector.PurgeDeadLetters,
+		},
+		// swagger:operation GET /deadletters/{id} deadletters GetDeadLetter
+		//
+		// Inspect a failed delivery
+		//
+		// Returns the failed delivery with the given id, which is the trace id of the original request, with its auth secrets and credential headers redacted. Replays still use the stored secrets.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: id
+		//   in: path
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//      description: OK
+		//   '404':
+		//      description: Not Found
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"GetDeadLetter",
+			"GET",
+			"/deadletters/{id}",
+			connector.GetDeadLetter,
+		},
+		// swagger:operation POST /deadletters/{id}/replay deadletters ReplayDeadLetter
+		//
+		// Replay a failed delivery
+		//
+		// Puts the failed delivery back at the end of the delivery queue and removes it from the dead letters. If it fails again it is stored as a new dead letter.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: id
+		//   in: path
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//      description: OK
+		//   '404':
+		//      description: Not Found
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"ReplayDeadLetter",
+			"POST",
+			"/deadletters/{id}/replay",
+			connector.ReplayDeadLetter,
+		},
+		// swagger:operation DELETE /deadletters/{id} deadletters DeleteDeadLetter
+		//
+		// Delete a failed delivery
+		//
+		// Removes the failed delivery with the given id without replaying it.
+		//
+		// ---
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: id
+		//   in: path
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '204':
+		//      description: No Content
+		//   '404':
+		//      description: Not Found
+		//   '500':
+		//      description: Internal server error
+		//
+		{
+			"DeleteDeadLetter",
+			"DELETE",
+			"/deadletters/{id}",
+			connector.DeleteDeadLetter,
 		},
 	}
 
@@ -9,8 +9,10 @@ package main
 import (
 	"context"
 	"flag"
+	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/cloudConnector"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/config"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/routes"
+	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/app/routes/handlers"
 	"github.com/intel/rsp-sw-toolkit-im-suite-cloud-connector-service/pkg/healthcheck"
 	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/configuration"
 	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
@@ -38,12 +40,26 @@ func main() {
 	}
 
 	isHealthyPtr := flag.Bool("isHealthy", false, "a bool, runs a healthcheck")
+	encryptPtr := flag.String("encrypt", "", "a file path, encrypts the file in place with the primary encryption key")
 	flag.Parse()
 
 	if *isHealthyPtr {
 		os.Exit(healthcheck.Healthcheck(config.AppConfig.Port))
 	}
 
+	keyring, err := cloudConnector.LoadKeyring(config.AppConfig.EncryptionKeyPath, config.AppConfig.EncryptionKeys)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cloudConnector.SetKeyring(keyring)
+
+	if *encryptPtr != "" {
+		if err := cloudConnector.EncryptFile(*encryptPtr); err != nil {
+			log.Fatal(err.Error())
+		}
+		os.Exit(0)
+	}
+
 	// Initialize metrics reporting
 	initMetrics()
 
@@ -54,8 +70,72 @@ func main() {
 		"Action": "Start",
 	}).Info("Starting application...")
 
+	tlsPolicy, err := cloudConnector.NewTLSPolicy(cloudConnector.TLSPolicySettings{
+		CABundlePaths: config.AppConfig.TLSCABundlePaths,
+		MinVersion:    config.AppConfig.TLSMinVersion,
+		CipherSuites:  config.AppConfig.TLSCipherSuites,
+		Pins:          config.AppConfig.TLSPins,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cloudConnector.SetTLSPolicy(tlsPolicy)
+	cloudConnector.SetAwsDefaultCredentials(config.AppConfig.AwsDefaultCredentials)
+	cloudConnector.SetTransportSettings(cloudConnector.TransportSettings{
+		MaxIdleConns:        config.AppConfig.HTTPMaxIdleConns,
+		MaxIdleConnsPerHost: config.AppConfig.HTTPMaxIdleConnsPerHost,
+		MaxConnsPerHost:     config.AppConfig.HTTPMaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(config.AppConfig.HTTPIdleConnTimeout) * time.Second,
+	})
+
+	jobs := cloudConnector.NewJobTracker(config.AppConfig.JobHistorySize)
+
+	deadLetters, err := cloudConnector.NewDeadLetterStore(config.AppConfig.DeadLetterPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	refreshTokens, err := cloudConnector.NewRefreshTokenStore(config.AppConfig.RefreshTokenPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cloudConnector.SetRefreshTokenStore(refreshTokens)
+
+	credentials, err := cloudConnector.NewCredentialStore(config.AppConfig.CredentialsPath, config.AppConfig.AllowInlineSecrets)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cloudConnector.SetCredentialStore(credentials)
+	log.WithFields(log.Fields{
+		"Method":      "main",
+		"Credentials": credentials.Len(),
+	}).Info("Loaded credential store")
+
+	// Open the persistent queue of async webhook deliveries and resume anything left from a previous run
+	deliveries, err := cloudConnector.NewDeliveryQueue(config.AppConfig.DeliveryQueuePath, cloudConnector.DeliveryQueueSettings{
+		Proxy:         config.AppConfig.HttpsProxyURL,
+		RetryInterval: time.Duration(config.AppConfig.DeliveryRetryInterval) * time.Second,
+		MaxAttempts:   config.AppConfig.DeliveryMaxAttempts,
+		DeadLetters:   deadLetters,
+		Jobs:          jobs,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	deliveries.Start()
+
+	if keyring == nil {
+		log.WithField("Method", "main").Warn("No encryption key configured, secrets are stored in plain text")
+	}
+	// Brings everything written before a key rotation, or before encryption was enabled, to the primary key
+	cloudConnector.ReencryptInBackground(deliveries, deadLetters, refreshTokens, credentials)
+
 	// Start Webserver
-	router := routes.NewRouter()
+	router := routes.NewRouter(&handlers.CloudConnector{
+		Deliveries:  deliveries,
+		DeadLetters: deadLetters,
+		Jobs:        jobs,
+	})
 
 	// Create a new server and set timeout values.
 	server := http.Server{
@@ -112,6 +192,9 @@ func main() {
 
 	// Wait for the listener to report it is closed.
 	wg.Wait()
+
+	// Let the in-flight delivery finish, everything else stays queued on disk
+	deliveries.Stop()
 	log.WithField("Method", "main").Info("Completed.")
 }
 